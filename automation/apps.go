@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+const (
+	// labels put on every resource the server creates
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+	ManagedByValue = "dapr-automation"
+	AppLabel       = "dapr-automation.io/app"
+
+	// Deployment annotation pointing at the linked state store Component, as namespace/name
+	StateStoreAnnotation = "dapr-automation.io/statestore"
+)
+
+var (
+	deploymentGVK = schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}
+	serviceGVK    = schema.GroupVersionKind{Group: "", Version: "v1", Kind: "Service"}
+	componentGVK  = schema.GroupVersionKind{Group: "dapr.io", Version: "v1alpha1", Kind: "Component"}
+)
+
+// AppInfo describes an app created through the API
+type AppInfo struct {
+	Name          string         `json:"name"`
+	Namespace     string         `json:"namespace"`
+	Images        []string       `json:"images"`
+	Replicas      int64          `json:"replicas"`
+	ReadyReplicas int64          `json:"readyReplicas"`
+	ExternalIP    string         `json:"externalIP,omitempty"`
+	StateStore    string         `json:"stateStore,omitempty"`
+	Deployment    Metadata       `json:"deployment"`
+	Service       *Metadata      `json:"service,omitempty"`
+	Component     *ComponentInfo `json:"component,omitempty"`
+	Error         string         `json:"error,omitempty"` // why the Service or Component is missing from a listed app
+}
+
+// ownership labels, app is left out when empty
+func managedLabels(app string) map[string]interface{} {
+	labels := map[string]interface{}{ManagedByLabel: ManagedByValue}
+	if app != "" {
+		labels[AppLabel] = app
+	}
+	return labels
+}
+
+func managedSelector() string {
+	return ManagedByLabel + "=" + ManagedByValue
+}
+
+// dynamic client for the resource of the given GVK, scoped to namespace when it is namespaced
+func (k *KubeClient) resourceInterface(gvk schema.GroupVersionKind, namespace string) (dynamic.ResourceInterface, error) {
+	mapping, err := k.restMapping(gvk)
+	if err != nil {
+		return nil, err
+	}
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		return k.c.Resource(mapping.Resource).Namespace(namespace), nil
+	}
+	return k.c.Resource(mapping.Resource), nil
+}
+
+// list managed apps, in all namespaces when namespace is empty
+func (k *KubeClient) ListApps(ctx context.Context, namespace string) ([]AppInfo, error) {
+	deployments, err := k.resourceInterface(deploymentGVK, namespace)
+	if err != nil {
+		return nil, err
+	}
+	list, err := deployments.List(ctx, metav1.ListOptions{LabelSelector: managedSelector()})
+	if err != nil {
+		return nil, err
+	}
+
+	apps := []AppInfo{}
+	for i := range list.Items {
+		// one app that cannot be described does not hide the others
+		app, err := k.appInfo(ctx, &list.Items[i])
+		if err != nil {
+			LoggerFrom(ctx).Warn("app info incomplete", "name", app.Name, "namespace", app.Namespace, "error", err)
+			app.Error = err.Error()
+		}
+		apps = append(apps, app)
+	}
+	return apps, nil
+}
+
+func (k *KubeClient) GetApp(ctx context.Context, namespace, name string) (AppInfo, error) {
+	deployment, err := k.getManagedDeployment(ctx, namespace, name)
+	if err != nil {
+		return AppInfo{}, err
+	}
+	return k.appInfo(ctx, deployment)
+}
+
+// fetch a Deployment, failing with NotFound when it is not managed by the server
+func (k *KubeClient) getManagedDeployment(ctx context.Context, namespace, name string) (*unstructured.Unstructured, error) {
+	deployments, err := k.resourceInterface(deploymentGVK, namespace)
+	if err != nil {
+		return nil, err
+	}
+	deployment, err := deployments.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if deployment.GetLabels()[ManagedByLabel] != ManagedByValue {
+		return nil, errors.NewNotFound(schema.GroupResource{Group: "apps", Resource: "deployments"}, name)
+	}
+	return deployment, nil
+}
+
+// collect the Deployment status together with its Service and state store Component
+func (k *KubeClient) appInfo(ctx context.Context, deployment *unstructured.Unstructured) (AppInfo, error) {
+	app := AppInfo{
+		Name:       deployment.GetName(),
+		Namespace:  deployment.GetNamespace(),
+		Images:     []string{},
+		StateStore: deployment.GetAnnotations()[StateStoreAnnotation],
+		Deployment: metadataFor(deployment, "deployments"),
+	}
+	app.Replicas, _, _ = unstructured.NestedInt64(deployment.Object, "spec", "replicas")
+	app.ReadyReplicas, _, _ = unstructured.NestedInt64(deployment.Object, "status", "readyReplicas")
+	containers, _, _ := unstructured.NestedSlice(deployment.Object, "spec", "template", "spec", "containers")
+	for _, c := range containers {
+		if image, ok := c.(map[string]interface{})["image"].(string); ok {
+			app.Images = append(app.Images, image)
+		}
+	}
+
+	// Service shares the Deployment name
+	services, err := k.resourceInterface(serviceGVK, app.Namespace)
+	if err != nil {
+		return app, err
+	}
+	service, err := services.Get(ctx, app.Name, metav1.GetOptions{})
+	switch {
+	case errors.IsNotFound(err):
+	case err != nil:
+		return app, err
+	default:
+		metadata := metadataFor(service, "services")
+		app.Service = &metadata
+		app.ExternalIP = externalIP(service)
+	}
+
+	// linked state store Component
+	if app.StateStore != "" {
+		namespace, name := splitNamespacedName(app.StateStore, app.Namespace)
+		component, err := k.GetComponent(ctx, namespace, name)
+		switch {
+		case errors.IsNotFound(err):
+		case err != nil:
+			return app, err
+		default:
+			app.Component = &component
+		}
+	}
+
+	return app, nil
+}
+
+// first load balancer ingress IP or hostname of a Service
+func externalIP(service *unstructured.Unstructured) string {
+	ingress, _, _ := unstructured.NestedSlice(service.Object, "status", "loadBalancer", "ingress")
+	if len(ingress) == 0 {
+		return ""
+	}
+	entry, _ := ingress[0].(map[string]interface{})
+	if ip, ok := entry["ip"].(string); ok && ip != "" {
+		return ip
+	}
+	hostname, _ := entry["hostname"].(string)
+	return hostname
+}
+
+// split namespace/name, using defaultNamespace when there is no namespace part
+func splitNamespacedName(value, defaultNamespace string) (string, string) {
+	if i := strings.Index(value, "/"); i >= 0 {
+		return value[:i], value[i+1:]
+	}
+	return defaultNamespace, value
+}
+
+func metadataFor(u *unstructured.Unstructured, resource string) Metadata {
+	return Metadata{
+		Name:       u.GetName(),
+		Namespace:  u.GetNamespace(),
+		ApiVersion: u.GetAPIVersion(),
+		Resource:   resource,
+		Kind:       u.GetKind(),
+
+		ResourceVersion: u.GetResourceVersion(),
+	}
+}
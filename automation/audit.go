@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	AuditSinkNone   = "none"
+	AuditSinkStdout = "stdout"
+	AuditSinkFile   = "file"
+
+	AuditOutcomeSuccess = "success"
+	AuditOutcomeFailure = "failure"
+
+	// header set by an authenticating proxy in front of the server
+	RemoteUserHeader = "X-Remote-User"
+	// number of events kept in memory by the stdout sink for queries
+	stdoutAuditBufferSize = 1000
+	// default number of events returned by an audit query
+	defaultAuditQueryLimit = 100
+)
+
+var (
+	auditSinkFlag = flag.String("audit-sink", AuditSinkStdout, "(optional) audit sink for cluster mutations: none, stdout or file")
+	auditFileFlag = flag.String("audit-file", "audit.log", "(optional) append-only audit log file, used with -audit-sink=file")
+)
+
+// AuditResource is one resource touched by an audited request
+type AuditResource struct {
+	Metadata
+	Operation string          `json:"operation"`
+	Patch     json.RawMessage `json:"patch,omitempty"`
+}
+
+type AuditEvent struct {
+	Time      time.Time       `json:"time"`
+	RequestID string          `json:"requestId"`
+	Caller    string          `json:"caller"`
+	Cluster   string          `json:"cluster,omitempty"`
+	Endpoint  string          `json:"endpoint"`
+	Request   interface{}     `json:"request,omitempty"`
+	Resources []AuditResource `json:"resources"`
+	Outcome   string          `json:"outcome"`
+	Status    int             `json:"status"`
+}
+
+type AuditQuery struct {
+	Since     time.Time
+	Until     time.Time
+	Cluster   string
+	Namespace string
+	Limit     int
+}
+
+// AuditSink stores audit events and answers queries over them
+type AuditSink interface {
+	Write(event AuditEvent) error
+	Query(q AuditQuery) ([]AuditEvent, error)
+}
+
+func NewAuditSink() (AuditSink, error) {
+	switch *auditSinkFlag {
+	case AuditSinkNone, "":
+		return nil, nil
+	case AuditSinkStdout:
+		return &stdoutAuditSink{}, nil
+	case AuditSinkFile:
+		return newFileAuditSink(*auditFileFlag)
+	default:
+		return nil, fmt.Errorf("unknown audit sink %q", *auditSinkFlag)
+	}
+}
+
+// match reports whether the event satisfies the query filters
+func (q AuditQuery) match(e AuditEvent) bool {
+	if !q.Since.IsZero() && e.Time.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && e.Time.After(q.Until) {
+		return false
+	}
+	if q.Cluster != "" && e.Cluster != q.Cluster {
+		return false
+	}
+	if q.Namespace == "" {
+		return true
+	}
+	for _, r := range e.Resources {
+		if r.Namespace == q.Namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// stdoutAuditSink prints events as JSON lines and keeps the most recent ones in memory
+type stdoutAuditSink struct {
+	mu     sync.Mutex
+	recent []AuditEvent
+}
+
+func (s *stdoutAuditSink) Write(event AuditEvent) error {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recent = append(s.recent, event)
+	if len(s.recent) > stdoutAuditBufferSize {
+		s.recent = s.recent[len(s.recent)-stdoutAuditBufferSize:]
+	}
+	_, err = fmt.Fprintln(os.Stdout, string(b))
+	return err
+}
+
+func (s *stdoutAuditSink) Query(q AuditQuery) ([]AuditEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	events := []AuditEvent{}
+	for _, e := range s.recent {
+		if q.match(e) {
+			events = append(events, e)
+		}
+	}
+	return limitAuditEvents(events, q.Limit), nil
+}
+
+// fileAuditSink appends events as JSON lines to a file
+type fileAuditSink struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+}
+
+func newFileAuditSink(path string) (*fileAuditSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &fileAuditSink{path: path, f: f}, nil
+}
+
+func (s *fileAuditSink) Write(event AuditEvent) error {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	return s.f.Sync()
+}
+
+func (s *fileAuditSink) Query(q AuditQuery) ([]AuditEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	events := []AuditEvent{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var e AuditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		if q.match(e) {
+			events = append(events, e)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return limitAuditEvents(events, q.Limit), nil
+}
+
+// keep the newest events when there are more than limit
+func limitAuditEvents(events []AuditEvent, limit int) []AuditEvent {
+	if limit > 0 && len(events) > limit {
+		return events[len(events)-limit:]
+	}
+	return events
+}
+
+// auditRecord collects what a single request changed while it runs
+type auditRecord struct {
+	mu        sync.Mutex
+	request   interface{}
+	resources []AuditResource
+}
+
+type auditRecordKey struct{}
+
+// add a touched resource to the audit record of the request, no-op outside audited requests
+func RecordAuditResource(ctx context.Context, meta Metadata, operation string, patch []byte) {
+	rec, ok := ctx.Value(auditRecordKey{}).(*auditRecord)
+	if !ok {
+		return
+	}
+	res := AuditResource{Metadata: meta, Operation: operation, Patch: redactPatch(meta.Kind, patch)}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.resources = append(rec.resources, res)
+}
+
+// copy of an apply patch that is safe to store, nil when it is not a JSON object
+func redactPatch(kind string, patch []byte) json.RawMessage {
+	content := map[string]interface{}{}
+	if len(patch) == 0 || json.Unmarshal(patch, &content) != nil {
+		return nil
+	}
+	redactObject(kind, content)
+	b, err := json.Marshal(content)
+	if err != nil {
+		return nil
+	}
+	return json.RawMessage(b)
+}
+
+// set the redacted request body of the audited request
+func RecordAuditRequest(ctx context.Context, req interface{}) {
+	rec, ok := ctx.Value(auditRecordKey{}).(*auditRecord)
+	if !ok {
+		return
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.request = req
+}
+
+// wrap a mutating handler so its outcome and the resources it touched are written to the audit sink
+func (s *Server) audited(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.audit == nil {
+			next(w, r)
+			return
+		}
+
+		rec := &auditRecord{resources: []AuditResource{}}
+		ctx := context.WithValue(r.Context(), auditRecordKey{}, rec)
+		status := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(status, r.WithContext(ctx))
+
+		outcome := AuditOutcomeSuccess
+		if status.status >= http.StatusBadRequest {
+			outcome = AuditOutcomeFailure
+		}
+		rec.mu.Lock()
+		event := AuditEvent{
+			Time:      time.Now().UTC(),
+			RequestID: RequestIDFrom(ctx),
+			Caller:    auditCaller(r),
+			Cluster:   ClusterFrom(ctx),
+			Endpoint:  r.Method + " " + r.URL.Path,
+			Request:   rec.request,
+			Resources: rec.resources,
+			Outcome:   outcome,
+			Status:    status.status,
+		}
+		rec.mu.Unlock()
+
+		if err := s.audit.Write(event); err != nil {
+			LoggerFrom(ctx).Error("failed to write audit event", "error", err)
+		}
+	}
+}
+
+func auditCaller(r *http.Request) string {
+	if user := r.Header.Get(RemoteUserHeader); user != "" {
+		return user
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Query the audit log
+func (s *Server) HandleAuditQuery(w http.ResponseWriter, r *http.Request) {
+	if s.audit == nil {
+		HandleNotFound(w, r, fmt.Errorf("audit log is disabled"))
+		return
+	}
+
+	// events of the cluster the request is for, like every other API route
+	q := AuditQuery{
+		Cluster:   ClusterFrom(r.Context()),
+		Namespace: r.URL.Query().Get("namespace"),
+		Limit:     defaultAuditQueryLimit,
+	}
+	var err error
+	if v := r.URL.Query().Get("since"); v != "" {
+		if q.Since, err = time.Parse(time.RFC3339, v); err != nil {
+			HandleBadRequest(w, r, fmt.Errorf("invalid since: %v", err))
+			return
+		}
+	}
+	if v := r.URL.Query().Get("until"); v != "" {
+		if q.Until, err = time.Parse(time.RFC3339, v); err != nil {
+			HandleBadRequest(w, r, fmt.Errorf("invalid until: %v", err))
+			return
+		}
+	}
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil {
+			HandleBadRequest(w, r, fmt.Errorf("invalid limit: %v", err))
+			return
+		}
+	}
+
+	events, err := s.audit.Query(q)
+	if err != nil {
+		HandleInternalServerError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, events)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRedactPatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		kind     string
+		patch    string
+		want     string
+		mustHide []string
+	}{
+		{
+			name:     "Secret data",
+			kind:     "Secret",
+			patch:    `{"data":{"password":"c2VjcmV0"},"stringData":{"token":"plain"}}`,
+			mustHide: []string{"c2VjcmV0", "plain"},
+		},
+		{
+			name:     "Component metadata values",
+			kind:     "Component",
+			patch:    `{"spec":{"metadata":[{"name":"redisHost","value":"redis:6379"},{"name":"redisPassword","value":"hunter2"}]}}`,
+			want:     `{"spec":{"metadata":[{"name":"redisHost","value":"redis:6379"},{"name":"redisPassword","value":"[REDACTED]"}]}}`,
+			mustHide: []string{"hunter2"},
+		},
+		{
+			name:     "last applied configuration",
+			kind:     "Deployment",
+			patch:    `{"metadata":{"annotations":{"kubectl.kubernetes.io/last-applied-configuration":"{\"env\":\"hunter2\"}","keep":"me"}}}`,
+			want:     `{"metadata":{"annotations":{"keep":"me"}}}`,
+			mustHide: []string{"hunter2"},
+		},
+		{
+			name:  "other kinds unchanged",
+			kind:  "ConfigMap",
+			patch: `{"data":{"key":"value"}}`,
+			want:  `{"data":{"key":"value"}}`,
+		},
+		{
+			name:  "no patch",
+			kind:  "Secret",
+			patch: "",
+			want:  "",
+		},
+		{
+			name:  "not a JSON object",
+			kind:  "Secret",
+			patch: `[{"op":"replace","path":"/data/password","value":"c2VjcmV0"}]`,
+			want:  "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string(redactPatch(tt.kind, []byte(tt.patch)))
+			if tt.want != "" || tt.mustHide == nil {
+				if got != tt.want {
+					t.Errorf("redactPatch() = %s, want %s", got, tt.want)
+				}
+			}
+			for _, secret := range tt.mustHide {
+				if strings.Contains(got, secret) {
+					t.Errorf("redactPatch() = %s, contains %q", got, secret)
+				}
+			}
+		})
+	}
+}
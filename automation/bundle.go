@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// apply order of well-known kinds, unknown kinds go right before workloads
+var bundleKindOrder = map[string]int{
+	"Namespace":                0,
+	"CustomResourceDefinition": 1,
+	"ServiceAccount":           2,
+	"Secret":                   2,
+	"ConfigMap":                2,
+	"PersistentVolumeClaim":    2,
+	"Role":                     3,
+	"ClusterRole":              3,
+	"RoleBinding":              3,
+	"ClusterRoleBinding":       3,
+	"Configuration":            4, // Dapr
+	"Resiliency":               4, // Dapr
+	"Component":                4, // Dapr
+	"Service":                  5,
+	"Deployment":               7,
+	"StatefulSet":              7,
+	"DaemonSet":                7,
+	"Job":                      7,
+	"CronJob":                  7,
+	"Subscription":             8, // Dapr
+	"Ingress":                  8,
+}
+
+const bundleUnknownKindOrder = 6
+
+type AppBundleRequest struct {
+	Name      string                   `json:"name"`      // app name used for the ownership label
+	Namespace string                   `json:"namespace"` // Kubernetes Namespace override for namespaced resources
+	Manifests []map[string]interface{} `json:"manifests"` // Kubernetes and Dapr manifests, any order
+
+	DryRun      bool  `json:"dryRun"`                // server-side dry run, also previews what would be pruned
+	Prune       bool  `json:"prune"`                 // delete resources of the app that are no longer in the bundle
+	Cascade     *bool `json:"cascade,omitempty"`     // prune dependents too, default true
+	GracePeriod *int  `json:"gracePeriod,omitempty"` // prune grace period in seconds, default of the resource when unset
+}
+
+type BundleResult struct {
+	DryRun  bool       `json:"dryRun,omitempty"`
+	Applied []Metadata `json:"applied"`
+	Pruned  []Metadata `json:"pruned,omitempty"`
+	Error   string     `json:"error,omitempty"`
+}
+
+func kindOrder(kind string) int {
+	if order, ok := bundleKindOrder[kind]; ok {
+		return order
+	}
+	return bundleUnknownKindOrder
+}
+
+// sort manifests into dependency order, keeping the request order within a kind group
+func sortBundle(objs []*unstructured.Unstructured) {
+	sort.SliceStable(objs, func(i, j int) bool { return kindOrder(objs[i].GetKind()) < kindOrder(objs[j].GetKind()) })
+}
+
+// apply every manifest of the bundle in dependency order, stopping at the first failure
+func (k *KubeClient) ApplyBundle(ctx context.Context, req *AppBundleRequest) (BundleResult, error) {
+	objs, err := manifestsToUnstructured(req.Manifests)
+	if err != nil {
+		return BundleResult{Applied: []Metadata{}}, err
+	}
+	return k.ApplyBundleObjects(ctx, req, objs)
+}
+
+// apply already decoded manifests as a bundle owned by req.Name, the manifests of req are ignored
+func (k *KubeClient) ApplyBundleObjects(ctx context.Context, req *AppBundleRequest, objs []*unstructured.Unstructured) (_ BundleResult, err error) {
+	ctx, span := StartSpan(ctx, "ApplyBundle")
+	defer func() { EndSpan(span, err) }()
+
+	if req.Name == "" {
+		return BundleResult{Applied: []Metadata{}}, badRequestf("bundle name is required")
+	}
+	result, err := k.ApplyManifests(ctx, &ApplyManifestsRequest{
+		Namespace:   req.Namespace,
+		DryRun:      req.DryRun,
+		Prune:       req.Prune,
+		App:         req.Name,
+		Cascade:     req.Cascade,
+		GracePeriod: req.GracePeriod,
+	}, objs)
+	bundle := BundleResult{DryRun: result.DryRun, Applied: result.Applied, Pruned: result.Pruned}
+	if bundle.Applied == nil {
+		bundle.Applied = []Metadata{}
+	}
+	return bundle, err
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestKindOrder(t *testing.T) {
+	tests := []struct {
+		before, after string
+	}{
+		{"Namespace", "CustomResourceDefinition"},
+		{"CustomResourceDefinition", "Secret"},
+		{"Secret", "Role"},
+		{"RoleBinding", "Component"},
+		{"Component", "Service"},
+		{"Service", "HorizontalPodAutoscaler"}, // unknown kinds go between Services and workloads
+		{"HorizontalPodAutoscaler", "Deployment"},
+		{"Deployment", "Subscription"},
+	}
+	for _, tt := range tests {
+		if kindOrder(tt.before) >= kindOrder(tt.after) {
+			t.Errorf("kindOrder(%s) = %d, want less than kindOrder(%s) = %d", tt.before, kindOrder(tt.before), tt.after, kindOrder(tt.after))
+		}
+	}
+}
+
+func TestSortBundle(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []string
+		want []string
+	}{
+		{
+			name: "dependency order",
+			in:   []string{"Deployment/app", "Service/app", "Component/statestore", "Namespace/ns"},
+			want: []string{"Namespace/ns", "Component/statestore", "Service/app", "Deployment/app"},
+		},
+		{
+			name: "request order within a kind group",
+			in:   []string{"ConfigMap/b", "Secret/a", "ConfigMap/a", "Deployment/x"},
+			want: []string{"ConfigMap/b", "Secret/a", "ConfigMap/a", "Deployment/x"},
+		},
+		{
+			name: "unknown kinds",
+			in:   []string{"Ingress/web", "Widget/w", "Secret/s"},
+			want: []string{"Secret/s", "Widget/w", "Ingress/web"},
+		},
+		{
+			name: "empty",
+			in:   []string{},
+			want: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objs := make([]*unstructured.Unstructured, len(tt.in))
+			for i, id := range tt.in {
+				objs[i] = objectFromID(id)
+			}
+			sortBundle(objs)
+			got := make([]string, len(objs))
+			for i, u := range objs {
+				got[i] = u.GetKind() + "/" + u.GetName()
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("sortBundle() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// object of the given Kind/name
+func objectFromID(id string) *unstructured.Unstructured {
+	parts := strings.SplitN(id, "/", 2)
+	u := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	u.SetKind(parts[0])
+	u.SetName(parts[1])
+	return u
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/homedir"
+)
+
+const (
+	// name of the cluster loaded from the current kubeconfig context when -clusters is empty
+	DefaultClusterName = "default"
+	// header naming the cluster a request is for
+	ClusterHeader = "X-Cluster"
+)
+
+// timeout for the connectivity check of a single cluster
+const clusterCheckTimeout = 5 * time.Second
+
+// largest JSON body searched for a cluster field. Bodies of requests that name their cluster
+// with neither path, query nor header are read whole to find it, larger ones are rejected with 400.
+const maxClusterPeekBytes = 10 << 20
+
+var (
+	kubeconfig     = flag.String("kubeconfig", defaultKubeconfig(), "(optional) absolute path to the kubeconfig file")
+	clusterNames   = flag.String("clusters", "", "(optional) comma separated kubeconfig contexts to load, each one is a cluster named after its context, empty loads the current context as \""+DefaultClusterName+"\"")
+	inCluster      = flag.Bool("in-cluster", false, "(optional) also load the cluster the server runs in from its service account")
+	inClusterName  = flag.String("in-cluster-name", "local", "(optional) cluster name of the in-cluster config")
+	defaultCluster = flag.String("default-cluster", "", "(optional) cluster used by requests that name none, defaults to the first loaded cluster")
+)
+
+func defaultKubeconfig() string {
+	// Fetch local kubeconfig, requires kubectl to be used on the environment before
+	if home := homedir.HomeDir(); home != "" {
+		return filepath.Join(home, ".kube", "config")
+	}
+	return ""
+}
+
+// Clusters holds one KubeClient per cluster the server manages
+type Clusters struct {
+	clients     map[string]*KubeClient
+	names       []string
+	defaultName string
+}
+
+type ClusterStatus struct {
+	Name      string `json:"name"`
+	Host      string `json:"host"`
+	Default   bool   `json:"default"`
+	Connected bool   `json:"connected"`
+	Version   string `json:"version,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+type clusterKey struct{}
+
+type requestCluster struct {
+	name   string
+	client *KubeClient
+}
+
+// load the clusters named by the -clusters and -in-cluster flags
+func NewClusters() (*Clusters, error) {
+	flag.Parse()
+
+	c := &Clusters{clients: map[string]*KubeClient{}}
+	add := func(name string, config *rest.Config) error {
+		if _, ok := c.clients[name]; ok {
+			return fmt.Errorf("cluster %q is configured twice", name)
+		}
+		client, err := NewKubeClient(config)
+		if err != nil {
+			return fmt.Errorf("cluster %s: %w", name, err)
+		}
+		c.clients[name] = &client
+		c.names = append(c.names, name)
+		return nil
+	}
+
+	contexts := []string{}
+	for _, name := range strings.Split(*clusterNames, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			contexts = append(contexts, name)
+		}
+	}
+	switch {
+	case len(contexts) > 0:
+		for _, name := range contexts {
+			config, err := kubeconfigContext(name)
+			if err != nil {
+				return nil, fmt.Errorf("cluster %s: %w", name, err)
+			}
+			if err := add(name, config); err != nil {
+				return nil, err
+			}
+		}
+	case !*inCluster:
+		// Use the current context in kubeconfig
+		config, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
+		if err != nil {
+			return nil, err
+		}
+		if err := add(DefaultClusterName, config); err != nil {
+			return nil, err
+		}
+	}
+	if *inCluster {
+		config, err := rest.InClusterConfig()
+		if err != nil {
+			return nil, fmt.Errorf("cluster %s: %w", *inClusterName, err)
+		}
+		if err := add(*inClusterName, config); err != nil {
+			return nil, err
+		}
+	}
+
+	c.defaultName = c.names[0]
+	if *defaultCluster != "" {
+		if _, ok := c.clients[*defaultCluster]; !ok {
+			return nil, fmt.Errorf("default cluster %q is not loaded", *defaultCluster)
+		}
+		c.defaultName = *defaultCluster
+	}
+	return c, nil
+}
+
+// client config of the named kubeconfig context
+func kubeconfigContext(name string) (*rest.Config, error) {
+	rules := &clientcmd.ClientConfigLoadingRules{ExplicitPath: *kubeconfig}
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: name}
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+}
+
+// the client of the named cluster, the default cluster when name is empty
+func (c *Clusters) Get(name string) (*KubeClient, bool) {
+	if name == "" {
+		name = c.defaultName
+	}
+	client, ok := c.clients[name]
+	return client, ok
+}
+
+func (c *Clusters) Default() *KubeClient {
+	return c.clients[c.defaultName]
+}
+
+// check every cluster's API server concurrently
+func (c *Clusters) Status(ctx context.Context) []ClusterStatus {
+	statuses := make([]ClusterStatus, len(c.names))
+	var wg sync.WaitGroup
+	for i, name := range c.names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			statuses[i] = c.status(ctx, name)
+		}(i, name)
+	}
+	wg.Wait()
+	return statuses
+}
+
+func (c *Clusters) status(ctx context.Context, name string) ClusterStatus {
+	client := c.clients[name]
+	status := ClusterStatus{Name: name, Host: client.config.Host, Default: name == c.defaultName}
+
+	ctx, cancel := context.WithTimeout(ctx, clusterCheckTimeout)
+	defer cancel()
+	body, err := client.discovery.RESTClient().Get().AbsPath("/version").Do(ctx).Raw()
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	var version struct {
+		GitVersion string `json:"gitVersion"`
+	}
+	if err := json.Unmarshal(body, &version); err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	status.Connected = true
+	status.Version = version.GitVersion
+	return status
+}
+
+// resolve the cluster of an API request and store its client in the request context.
+// The cluster comes from the /api/clusters/{cluster} path prefix, the cluster query
+// parameter, the X-Cluster header or the cluster field of a JSON body, in that order.
+func (s *Server) clusterMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, err := clusterName(w, r)
+		if err != nil {
+			HandleBadRequest(w, r, err)
+			return
+		}
+		client, ok := s.clusters.Get(name)
+		if !ok {
+			HandleNotFound(w, r, fmt.Errorf("unknown cluster %q", name))
+			return
+		}
+		if name == "" {
+			name = s.clusters.defaultName
+		}
+		ctx := context.WithValue(r.Context(), clusterKey{}, requestCluster{name: name, client: client})
+		ctx = WithLogger(ctx, LoggerFrom(ctx).With("cluster", name))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func clusterName(w http.ResponseWriter, r *http.Request) (string, error) {
+	if name := mux.Vars(r)["cluster"]; name != "" {
+		return name, nil
+	}
+	if name := r.URL.Query().Get("cluster"); name != "" {
+		return name, nil
+	}
+	if name := r.Header.Get(ClusterHeader); name != "" {
+		return name, nil
+	}
+	// YAML bodies name their cluster with the path, query or header only
+	if r.Body == nil || r.Body == http.NoBody || strings.Contains(r.Header.Get("Content-Type"), "yaml") {
+		return "", nil
+	}
+
+	// peek at the body and put it back for the handler
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxClusterPeekBytes))
+	r.Body.Close()
+	if err != nil {
+		return "", err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	var peek struct {
+		Cluster string `json:"cluster"`
+	}
+	// bodies that are not a JSON object are left for the handler to reject
+	json.Unmarshal(body, &peek)
+	return peek.Cluster, nil
+}
+
+// the client of the cluster the request is for
+func (s *Server) client(r *http.Request) *KubeClient {
+	if c, ok := r.Context().Value(clusterKey{}).(requestCluster); ok {
+		return c.client
+	}
+	return s.clusters.Default()
+}
+
+// return the name of the cluster the request is for, empty outside the API
+func ClusterFrom(ctx context.Context) string {
+	c, _ := ctx.Value(clusterKey{}).(requestCluster)
+	return c.name
+}
+
+// list the configured clusters and whether their API servers are reachable
+func (s *Server) HandleClusters(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.clusters.Status(r.Context()))
+}
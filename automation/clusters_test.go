@@ -0,0 +1,148 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestClusterName(t *testing.T) {
+	tests := []struct {
+		name        string
+		path        string
+		header      string
+		contentType string
+		body        string
+		want        string
+	}{
+		{name: "none", path: "/api/apps", want: ""},
+		{name: "path", path: "/api/clusters/dev/apps?cluster=staging", header: "prod", contentType: "application/json", body: `{"cluster":"body"}`, want: "dev"},
+		{name: "query over header and body", path: "/api/apps?cluster=staging", header: "prod", contentType: "application/json", body: `{"cluster":"body"}`, want: "staging"},
+		{name: "header over body", path: "/api/apps", header: "prod", contentType: "application/json", body: `{"cluster":"body"}`, want: "prod"},
+		{name: "JSON body", path: "/api/apps", contentType: "application/json", body: `{"cluster":"body","name":"app"}`, want: "body"},
+		{name: "body without content type", path: "/api/apps", body: `{"cluster":"body"}`, want: "body"},
+		{name: "YAML body is not read", path: "/api/apps", contentType: "application/yaml", body: "cluster: body\n", want: ""},
+		{name: "body that is not an object", path: "/api/apps", contentType: "application/json", body: `[1, 2]`, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got, gotBody string
+			router := mux.NewRouter()
+			handler := func(w http.ResponseWriter, r *http.Request) {
+				var err error
+				if got, err = clusterName(w, r); err != nil {
+					t.Fatalf("clusterName() error = %v", err)
+				}
+				b, _ := ioutil.ReadAll(r.Body)
+				gotBody = string(b)
+			}
+			router.HandleFunc("/api/clusters/{cluster}/apps", handler)
+			router.HandleFunc("/api/apps", handler)
+
+			r := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			if tt.body == "" {
+				r.Body = http.NoBody
+			}
+			if tt.header != "" {
+				r.Header.Set(ClusterHeader, tt.header)
+			}
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			router.ServeHTTP(httptest.NewRecorder(), r)
+
+			if got != tt.want {
+				t.Errorf("clusterName() = %q, want %q", got, tt.want)
+			}
+			if gotBody != tt.body {
+				t.Errorf("body after clusterName() = %q, want %q", gotBody, tt.body)
+			}
+		})
+	}
+}
+
+func TestClusterNameBodyTooLarge(t *testing.T) {
+	body := `{"cluster":"body","pad":"` + strings.Repeat("x", maxClusterPeekBytes) + `"}`
+	r := httptest.NewRequest(http.MethodPost, "/api/apps", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	if _, err := clusterName(httptest.NewRecorder(), r); err == nil {
+		t.Errorf("clusterName() error = nil for a body over %d bytes", maxClusterPeekBytes)
+	}
+
+	// the body is not read when the cluster is named otherwise
+	r = httptest.NewRequest(http.MethodPost, "/api/apps?cluster=dev", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	if got, err := clusterName(httptest.NewRecorder(), r); err != nil || got != "dev" {
+		t.Errorf("clusterName() = %q, %v, want %q", got, err, "dev")
+	}
+}
+
+func TestClusterMiddleware(t *testing.T) {
+	dev, prod := &KubeClient{}, &KubeClient{}
+	s := &Server{clusters: &Clusters{
+		clients:     map[string]*KubeClient{"dev": dev, "prod": prod},
+		names:       []string{"dev", "prod"},
+		defaultName: "prod",
+	}}
+
+	var got *KubeClient
+	var gotName string
+	router := mux.NewRouter()
+	api := router.PathPrefix("/api").Subrouter()
+	api.Use(s.clusterMiddleware)
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		got, gotName = s.client(r), ClusterFrom(r.Context())
+	}
+	api.HandleFunc("/clusters/{cluster}/apps", handler)
+	api.HandleFunc("/apps", handler)
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+		want       *KubeClient
+		wantName   string
+	}{
+		{"default", "/api/apps", http.StatusOK, prod, "prod"},
+		{"path", "/api/clusters/dev/apps", http.StatusOK, dev, "dev"},
+		{"query", "/api/apps?cluster=dev", http.StatusOK, dev, "dev"},
+		{"unknown", "/api/clusters/nope/apps", http.StatusNotFound, nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, gotName = nil, ""
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got != tt.want || gotName != tt.wantName {
+				t.Errorf("cluster = %q (%p), want %q (%p)", gotName, got, tt.wantName, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuditQueryMatchCluster(t *testing.T) {
+	tests := []struct {
+		name    string
+		cluster string
+		event   string
+		want    bool
+	}{
+		{"same cluster", "dev", "dev", true},
+		{"other cluster", "dev", "prod", false},
+		{"event without cluster", "dev", "", false},
+		{"any cluster", "", "prod", true},
+	}
+	for _, tt := range tests {
+		q := AuditQuery{Cluster: tt.cluster}
+		if got := q.match(AuditEvent{Cluster: tt.event}); got != tt.want {
+			t.Errorf("%s: match() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
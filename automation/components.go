@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// ComponentInfo describes a Dapr Component with inline secret values redacted
+type ComponentInfo struct {
+	Metadata
+	Type        string               `json:"type"`
+	Version     string               `json:"version"`
+	Scopes      []string             `json:"scopes"`
+	SecretStore string               `json:"secretStore,omitempty"`
+	SecretRefs  []ComponentSecretRef `json:"secretRefs"`
+	Fields      []ComponentField     `json:"fields"`
+}
+
+// ComponentSecretRef is a metadata item whose value is read from a secret store
+type ComponentSecretRef struct {
+	Field      string `json:"field"`
+	SecretName string `json:"secretName"`
+	SecretKey  string `json:"secretKey,omitempty"`
+}
+
+// ComponentField is an inline metadata item
+type ComponentField struct {
+	Name     string `json:"name"`
+	Value    string `json:"value"`
+	Redacted bool   `json:"redacted,omitempty"`
+}
+
+// list Dapr Components, in all namespaces when namespace is empty
+func (k *KubeClient) ListComponents(ctx context.Context, namespace string) ([]ComponentInfo, error) {
+	components, err := k.resourceInterface(componentGVK, namespace)
+	if err != nil {
+		return nil, err
+	}
+	list, err := components.List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	infos := []ComponentInfo{}
+	for i := range list.Items {
+		infos = append(infos, componentInfo(&list.Items[i]))
+	}
+	return infos, nil
+}
+
+func (k *KubeClient) GetComponent(ctx context.Context, namespace, name string) (ComponentInfo, error) {
+	components, err := k.resourceInterface(componentGVK, namespace)
+	if err != nil {
+		return ComponentInfo{}, err
+	}
+	component, err := components.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return ComponentInfo{}, err
+	}
+	return componentInfo(component), nil
+}
+
+func componentInfo(u *unstructured.Unstructured) ComponentInfo {
+	info := ComponentInfo{
+		Metadata:   metadataFor(u, "components"),
+		Scopes:     []string{},
+		SecretRefs: []ComponentSecretRef{},
+		Fields:     []ComponentField{},
+	}
+	info.Type, _, _ = unstructured.NestedString(u.Object, "spec", "type")
+	info.Version, _, _ = unstructured.NestedString(u.Object, "spec", "version")
+	info.SecretStore, _, _ = unstructured.NestedString(u.Object, "auth", "secretStore")
+	if scopes, found, _ := unstructured.NestedStringSlice(u.Object, "scopes"); found {
+		info.Scopes = scopes
+	}
+
+	content := u.DeepCopy().Object
+	redactObject("Component", content)
+	items, _, _ := unstructured.NestedSlice(content, "spec", "metadata")
+	for _, item := range items {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _ := m["name"].(string)
+		if ref, ok := m["secretKeyRef"].(map[string]interface{}); ok {
+			secretName, _ := ref["name"].(string)
+			secretKey, _ := ref["key"].(string)
+			info.SecretRefs = append(info.SecretRefs, ComponentSecretRef{Field: name, SecretName: secretName, SecretKey: secretKey})
+			continue
+		}
+
+		value := ""
+		if v, ok := m["value"]; ok && v != nil {
+			value = fmt.Sprint(v)
+		}
+		info.Fields = append(info.Fields, ComponentField{Name: name, Value: value, Redacted: value == redactedValue})
+	}
+	return info
+}
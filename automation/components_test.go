@@ -0,0 +1,86 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestIsSecretField(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"redisPassword", true},
+		{"PASSWORD", true},
+		{"accessToken", true},
+		{"accountKey", true},
+		{"connectionString", true},
+		{"clientSecret", true},
+		{"awsCredentials", true},
+		{"redisHost", false},
+		{"enableTLS", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := isSecretField(tt.name); got != tt.want {
+			t.Errorf("isSecretField(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestComponentInfo(t *testing.T) {
+	u := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "dapr.io/v1alpha1",
+		"kind":       "Component",
+		"metadata": map[string]interface{}{
+			"name":        "statestore",
+			"namespace":   "default",
+			"annotations": map[string]interface{}{lastAppliedAnnotation: `{"spec":{"metadata":[{"name":"redisPassword","value":"hunter2"}]}}`},
+		},
+		"spec": map[string]interface{}{
+			"type":    "state.redis",
+			"version": "v1",
+			"metadata": []interface{}{
+				map[string]interface{}{"name": "redisHost", "value": "redis:6379"},
+				map[string]interface{}{"name": "redisPassword", "value": "hunter2"},
+				map[string]interface{}{"name": "accessToken", "value": "abc"},
+				map[string]interface{}{"name": "accountKey", "value": "def"},
+				map[string]interface{}{"name": "connectionString", "value": "Server=db;Password=ghi"},
+				map[string]interface{}{"name": "emptyPassword", "value": ""},
+				map[string]interface{}{"name": "maxRetries", "value": int64(3)},
+				map[string]interface{}{"name": "redisUsername", "secretKeyRef": map[string]interface{}{"name": "redis", "key": "username"}},
+			},
+		},
+		"auth":   map[string]interface{}{"secretStore": "kubernetes"},
+		"scopes": []interface{}{"app"},
+	}}
+
+	info := componentInfo(u)
+	if info.Type != "state.redis" || info.Version != "v1" || info.SecretStore != "kubernetes" || !reflect.DeepEqual(info.Scopes, []string{"app"}) {
+		t.Errorf("componentInfo() = %+v", info)
+	}
+	wantFields := []ComponentField{
+		{Name: "redisHost", Value: "redis:6379"},
+		{Name: "redisPassword", Value: redactedValue, Redacted: true},
+		{Name: "accessToken", Value: redactedValue, Redacted: true},
+		{Name: "accountKey", Value: redactedValue, Redacted: true},
+		{Name: "connectionString", Value: redactedValue, Redacted: true},
+		{Name: "emptyPassword", Value: ""},
+		{Name: "maxRetries", Value: "3"},
+	}
+	if !reflect.DeepEqual(info.Fields, wantFields) {
+		t.Errorf("componentInfo() fields = %+v, want %+v", info.Fields, wantFields)
+	}
+	wantRefs := []ComponentSecretRef{{Field: "redisUsername", SecretName: "redis", SecretKey: "username"}}
+	if !reflect.DeepEqual(info.SecretRefs, wantRefs) {
+		t.Errorf("componentInfo() secretRefs = %+v, want %+v", info.SecretRefs, wantRefs)
+	}
+
+	// the object itself is left untouched
+	items, _, _ := unstructured.NestedSlice(u.Object, "spec", "metadata")
+	if value := items[1].(map[string]interface{})["value"]; value != "hunter2" {
+		t.Errorf("componentInfo() changed the object, redisPassword = %v", value)
+	}
+}
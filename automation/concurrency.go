@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	maxConcurrentRequests = flag.Int("max-concurrent-requests", 64, "(optional) API requests handled at once, further requests get 429, 0 disables the limit")
+	concurrencyRetryAfter = flag.Duration("concurrency-retry-after", time.Second, "(optional) Retry-After sent with 429 responses")
+)
+
+// long-lived streams are not counted against the limit, they would hold a slot for their whole lifetime
+var unlimitedRoutes = map[string]bool{
+	"/api/apps/{namespace}/{name}/logs": true,
+	"/api/watch":                        true,
+	"/api/clusters/{cluster}/apps/{namespace}/{name}/logs": true,
+	"/api/clusters/{cluster}/watch":                        true,
+}
+
+var httpRequestsRejectedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "http_requests_rejected_total",
+	Help:      "Number of API requests rejected with 429 because the concurrency limit was reached.",
+}, []string{"route", "method"})
+
+// reject requests beyond -max-concurrent-requests with 429 and Retry-After instead of queueing them
+func concurrencyLimitMiddleware() mux.MiddlewareFunc {
+	if *maxConcurrentRequests <= 0 {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	slots := make(chan struct{}, *maxConcurrentRequests)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := routeTemplate(r)
+			if unlimitedRoutes[route] {
+				next.ServeHTTP(w, r)
+				return
+			}
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+				next.ServeHTTP(w, r)
+			default:
+				httpRequestsRejectedTotal.WithLabelValues(route, r.Method).Inc()
+				LoggerFrom(r.Context()).Warn("concurrency limit reached", "limit", *maxConcurrentRequests)
+				retryAfter := int((*concurrencyRetryAfter + time.Second - 1) / time.Second)
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+				w.WriteHeader(http.StatusTooManyRequests)
+				w.Write([]byte("too many concurrent requests, retry later"))
+			}
+		})
+	}
+}
+
+// appLocks serializes operations on the same app, keyed by namespace and app name
+type appLocks struct {
+	mu    sync.Mutex
+	locks map[string]*appLock
+}
+
+type appLock struct {
+	held chan struct{}
+	refs int
+}
+
+func newAppLocks() *appLocks {
+	return &appLocks{locks: map[string]*appLock{}}
+}
+
+// wait for the app's lock until ctx is done, call the returned func to release it
+func (l *appLocks) lock(ctx context.Context, namespace, app string) (func(), error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+	key := namespace + "/" + app
+
+	l.mu.Lock()
+	lock, ok := l.locks[key]
+	if !ok {
+		lock = &appLock{held: make(chan struct{}, 1)}
+		l.locks[key] = lock
+	}
+	lock.refs++
+	l.mu.Unlock()
+
+	release := func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(l.locks, key)
+		}
+	}
+
+	select {
+	case lock.held <- struct{}{}:
+		return func() {
+			<-lock.held
+			release()
+		}, nil
+	case <-ctx.Done():
+		release()
+		return nil, ctx.Err()
+	}
+}
+
+// lock the app in each of the namespaces, always in the same order so two callers cannot deadlock
+func (l *appLocks) lockAll(ctx context.Context, namespaces []string, app string) (func(), error) {
+	unique := map[string]bool{}
+	for _, namespace := range namespaces {
+		if namespace == "" {
+			namespace = "default"
+		}
+		unique[namespace] = true
+	}
+	sorted := make([]string, 0, len(unique))
+	for namespace := range unique {
+		sorted = append(sorted, namespace)
+	}
+	sort.Strings(sorted)
+
+	unlocks := []func(){}
+	unlockAll := func() {
+		for i := len(unlocks) - 1; i >= 0; i-- {
+			unlocks[i]()
+		}
+	}
+	for _, namespace := range sorted {
+		unlock, err := l.lock(ctx, namespace, app)
+		if err != nil {
+			unlockAll()
+			return nil, err
+		}
+		unlocks = append(unlocks, unlock)
+	}
+	return unlockAll, nil
+}
+
+// lock of a Dapr Component, taken after the app's lock when both are needed
+func (l *appLocks) lockComponent(ctx context.Context, namespace, name string) (func(), error) {
+	// app names cannot contain a slash, so the key never collides with an app's
+	return l.lock(ctx, namespace, "component/"+name)
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestAppLocks(t *testing.T) {
+	locks := newAppLocks()
+	ctx := context.Background()
+
+	unlock, err := locks.lock(ctx, "default", "app")
+	if err != nil {
+		t.Fatalf("lock() error = %v", err)
+	}
+
+	// another app and the same name in another namespace do not contend
+	for _, key := range [][2]string{{"default", "other"}, {"prod", "app"}} {
+		other, err := locks.lock(ctx, key[0], key[1])
+		if err != nil {
+			t.Fatalf("lock(%s/%s) error = %v", key[0], key[1], err)
+		}
+		other()
+	}
+
+	// the empty namespace is the default one, so it contends
+	timeoutCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := locks.lock(timeoutCtx, "", "app"); err != context.DeadlineExceeded {
+		t.Fatalf("lock() while held error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// a waiter gets the lock once it is released
+	acquired := make(chan func())
+	go func() {
+		next, err := locks.lock(ctx, "default", "app")
+		if err != nil {
+			t.Errorf("lock() error = %v", err)
+		}
+		acquired <- next
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("lock() acquired while held")
+	case <-time.After(20 * time.Millisecond):
+	}
+	unlock()
+	select {
+	case next := <-acquired:
+		next()
+	case <-time.After(time.Second):
+		t.Fatal("lock() not acquired after release")
+	}
+
+	// cancelled waiters and released holders leave nothing behind
+	locks.mu.Lock()
+	defer locks.mu.Unlock()
+	if len(locks.locks) != 0 {
+		t.Errorf("locks left = %d, want 0", len(locks.locks))
+	}
+}
+
+func TestAppLocksCancelledWaiter(t *testing.T) {
+	locks := newAppLocks()
+	unlock, err := locks.lock(context.Background(), "default", "app")
+	if err != nil {
+		t.Fatalf("lock() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error)
+	go func() {
+		_, err := locks.lock(ctx, "default", "app")
+		errc <- err
+	}()
+	cancel()
+	if err := <-errc; err != context.Canceled {
+		t.Fatalf("lock() error = %v, want %v", err, context.Canceled)
+	}
+
+	// the cancelled waiter must not have taken the lock
+	unlock()
+	again, err := locks.lock(context.Background(), "default", "app")
+	if err != nil {
+		t.Fatalf("lock() after cancel error = %v", err)
+	}
+	again()
+}
+
+func TestConcurrencyLimitMiddleware(t *testing.T) {
+	defer func(limit int, retryAfter time.Duration) {
+		*maxConcurrentRequests, *concurrencyRetryAfter = limit, retryAfter
+	}(*maxConcurrentRequests, *concurrencyRetryAfter)
+	*maxConcurrentRequests = 1
+	*concurrencyRetryAfter = 1500 * time.Millisecond
+
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	blocking := func(w http.ResponseWriter, r *http.Request) {
+		entered <- struct{}{}
+		<-release
+	}
+	router := mux.NewRouter()
+	api := router.PathPrefix("/api").Subrouter()
+	api.Use(concurrencyLimitMiddleware())
+	api.HandleFunc("/apps", blocking)
+	api.HandleFunc("/watch", func(w http.ResponseWriter, r *http.Request) {})
+
+	done := make(chan int)
+	go func() {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/apps", nil))
+		done <- rec.Code
+	}()
+	<-entered
+
+	tests := []struct {
+		name           string
+		path           string
+		wantStatus     int
+		wantRetryAfter string
+	}{
+		{"limit reached", "/api/apps", http.StatusTooManyRequests, "2"},
+		{"streams are not limited", "/api/watch", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Retry-After"); got != tt.wantRetryAfter {
+				t.Errorf("Retry-After = %q, want %q", got, tt.wantRetryAfter)
+			}
+		})
+	}
+
+	close(release)
+	if code := <-done; code != http.StatusOK {
+		t.Errorf("first request status = %d, want %d", code, http.StatusOK)
+	}
+
+	// the slot is free again
+	go func() { <-entered }()
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/apps", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status after release = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestAppLocksLockAll(t *testing.T) {
+	locks := newAppLocks()
+	ctx := context.Background()
+
+	unlock, err := locks.lockAll(ctx, []string{"prod", "", "default", "prod"}, "app")
+	if err != nil {
+		t.Fatalf("lockAll() error = %v", err)
+	}
+	locks.mu.Lock()
+	held := len(locks.locks)
+	locks.mu.Unlock()
+	if held != 2 {
+		t.Errorf("locks held = %d, want 2", held)
+	}
+
+	// every namespace is taken, a single lock in any of them contends
+	for _, namespace := range []string{"default", "prod"} {
+		timeoutCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		if _, err := locks.lock(timeoutCtx, namespace, "app"); err != context.DeadlineExceeded {
+			t.Errorf("lock(%s) while held error = %v, want %v", namespace, err, context.DeadlineExceeded)
+		}
+		cancel()
+	}
+
+	// a lockAll that cannot take every lock releases the ones it took
+	other, err := locks.lock(ctx, "staging", "app")
+	if err != nil {
+		t.Fatalf("lock() error = %v", err)
+	}
+	unlock()
+	timeoutCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := locks.lockAll(timeoutCtx, []string{"default", "staging"}, "app"); err != context.DeadlineExceeded {
+		t.Fatalf("lockAll() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	releasedCtx, cancelReleased := context.WithTimeout(ctx, time.Second)
+	defer cancelReleased()
+	again, err := locks.lock(releasedCtx, "default", "app")
+	if err != nil {
+		t.Fatalf("lock() after failed lockAll() error = %v", err)
+	}
+	again()
+	other()
+
+	// components do not contend with apps of the same name
+	component, err := locks.lockComponent(ctx, "default", "app")
+	if err != nil {
+		t.Fatalf("lockComponent() error = %v", err)
+	}
+	app, err := locks.lock(ctx, "default", "app")
+	if err != nil {
+		t.Fatalf("lock() error = %v", err)
+	}
+	app()
+	component()
+
+	locks.mu.Lock()
+	defer locks.mu.Unlock()
+	if len(locks.locks) != 0 {
+		t.Errorf("locks left = %d, want 0", len(locks.locks))
+	}
+}
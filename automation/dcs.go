@@ -1,18 +1,39 @@
 package main
 
 import (
+	"context"
 	"encoding/base64"
 	"fmt"
+	"time"
 
 	"github.com/huaweicloud/huaweicloud-sdk-go-v3/core/auth/basic"
 	dcs "github.com/huaweicloud/huaweicloud-sdk-go-v3/services/dcs/v2"
 	"github.com/huaweicloud/huaweicloud-sdk-go-v3/services/dcs/v2/model"
 	region "github.com/huaweicloud/huaweicloud-sdk-go-v3/services/dcs/v2/region"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+// Huaweicloud region where DCS instances are looked up
+const dcsRegion = "cn-north-4"
+
 // find DCS instance under user's account by name
 // return DCS host, isNoPasswordAccess, decoded password and error
-func FindDCS(req *DCSConnectRequest) (string, string, string, error) {
+func FindDCS(ctx context.Context, req *DCSConnectRequest) (string, string, string, error) {
+	start := time.Now()
+	ctx, span := StartSpan(ctx, "FindDCS", attribute.String("dcs.name", req.DCSName))
+	host, noPasswordAccess, password, err := findDCS(ctx, req)
+	EndSpan(span, err)
+	dcsLookupDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		dcsLookupErrorsTotal.Inc()
+		LoggerFrom(ctx).Error("DCS lookup failed", "dcsName", req.DCSName, "error", err)
+	} else {
+		LoggerFrom(ctx).Debug("DCS found", "dcsName", req.DCSName, "host", host)
+	}
+	return host, noPasswordAccess, password, err
+}
+
+func findDCS(ctx context.Context, req *DCSConnectRequest) (string, string, string, error) {
 	realAK, err := base64.StdEncoding.DecodeString(req.AK)
 	if err != nil {
 		return "", "", "", err
@@ -33,14 +54,16 @@ func FindDCS(req *DCSConnectRequest) (string, string, string, error) {
 
 	client := dcs.NewDcsClient(
 		dcs.DcsClientBuilder().
-			WithRegion(region.ValueOf("cn-north-4")).
+			WithRegion(region.ValueOf(dcsRegion)).
 			WithCredential(auth).
 			Build())
 
 	request := &model.ListInstancesRequest{}
+	_, span := StartSpan(ctx, "dcs.ListInstances", attribute.String("cloud.region", dcsRegion))
 	response, err := client.ListInstances(request)
+	EndSpan(span, err)
 	if err != nil {
-		fmt.Println(err)
+		return "", "", "", err
 	}
 	if *response.InstanceNum == 0 {
 		return "", "", "", fmt.Errorf("your account does not have any DCS instances")
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	SeverityCritical = "critical"
+	SeverityWarning  = "warning"
+	SeverityInfo     = "info"
+)
+
+// rank of each severity, higher is listed first
+var severityRank = map[string]int{
+	SeverityCritical: 3,
+	SeverityWarning:  2,
+	SeverityInfo:     1,
+}
+
+var eventGVK = schema.GroupVersionKind{Group: "", Version: "v1", Kind: "Event"}
+
+// container waiting reasons caused by the image
+var imagePullReasons = map[string]bool{
+	"ErrImagePull":      true,
+	"ImagePullBackOff":  true,
+	"InvalidImageName":  true,
+	"ErrImageNeverPull": true,
+}
+
+type Diagnosis struct {
+	App      AppInfo       `json:"app"`
+	Sidecar  SidecarStatus `json:"sidecar"`
+	Problems []Problem     `json:"problems"`
+	Events   []EventInfo   `json:"events"`
+}
+
+// SidecarStatus compares the requested Dapr injection with what the pods run
+type SidecarStatus struct {
+	Enabled      bool              `json:"enabled"`
+	AppID        string            `json:"appId,omitempty"`
+	Annotations  map[string]string `json:"annotations"`
+	Pods         int               `json:"pods"`
+	InjectedPods int               `json:"injectedPods"`
+}
+
+// Problem is a likely cause of the app not working
+type Problem struct {
+	Severity string `json:"severity"`
+	Resource string `json:"resource"`
+	Reason   string `json:"reason"`
+	Message  string `json:"message"`
+}
+
+type EventInfo struct {
+	Type     string    `json:"type"`
+	Reason   string    `json:"reason"`
+	Object   string    `json:"object"`
+	Message  string    `json:"message"`
+	Count    int64     `json:"count"`
+	LastSeen time.Time `json:"lastSeen"`
+}
+
+// collect events and status of the app and rank the likely problems
+func (k *KubeClient) DiagnoseApp(ctx context.Context, namespace, name string) (Diagnosis, error) {
+	deployment, err := k.getManagedDeployment(ctx, namespace, name)
+	if err != nil {
+		return Diagnosis{}, err
+	}
+	app, err := k.appInfo(ctx, deployment)
+	if err != nil {
+		return Diagnosis{}, err
+	}
+	replicaSets, err := k.ownedReplicaSets(ctx, deployment)
+	if err != nil {
+		return Diagnosis{}, err
+	}
+	pods, err := k.deploymentPods(ctx, deployment)
+	if err != nil {
+		return Diagnosis{}, err
+	}
+
+	d := Diagnosis{App: app, Problems: []Problem{}}
+
+	// objects whose events are relevant
+	objects := map[string]bool{"Deployment/" + name: true, "Service/" + name: true}
+	for _, rs := range replicaSets {
+		objects["ReplicaSet/"+rs.GetName()] = true
+	}
+	for _, pod := range pods {
+		objects["Pod/"+pod.GetName()] = true
+	}
+	d.Events, err = k.eventsFor(ctx, namespace, objects)
+	if err != nil {
+		return Diagnosis{}, err
+	}
+
+	d.Sidecar = sidecarStatus(deployment, pods)
+	d.Problems = append(d.Problems, sidecarProblems(name, d.Sidecar)...)
+	d.Problems = append(d.Problems, deploymentProblems(app)...)
+	for i := range pods {
+		d.Problems = append(d.Problems, podProblems(&pods[i])...)
+	}
+
+	if app.StateStore == "" {
+		d.Problems = append(d.Problems, Problem{SeverityInfo, "Deployment/" + name, "NoStateStore", "app is not linked to a state store Component"})
+	} else if app.Component == nil {
+		d.Problems = append(d.Problems, Problem{SeverityCritical, "Component/" + app.StateStore, "ComponentMissing", fmt.Sprintf("state store Component %s does not exist", app.StateStore)})
+	}
+
+	for _, e := range d.Events {
+		if e.Type == "Warning" {
+			d.Problems = append(d.Problems, Problem{SeverityWarning, e.Object, e.Reason, e.Message})
+		}
+	}
+
+	sort.SliceStable(d.Problems, func(i, j int) bool {
+		return severityRank[d.Problems[i].Severity] > severityRank[d.Problems[j].Severity]
+	})
+	return d, nil
+}
+
+// events in the namespace involving one of the objects, newest first
+func (k *KubeClient) eventsFor(ctx context.Context, namespace string, objects map[string]bool) ([]EventInfo, error) {
+	ri, err := k.resourceInterface(eventGVK, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	// the server filters by involved object, busy namespaces have far more events than the app
+	items := []unstructured.Unstructured{}
+	for object := range objects {
+		parts := strings.SplitN(object, "/", 2)
+		selector := fields.Set{
+			"involvedObject.namespace": namespace,
+			"involvedObject.kind":      parts[0],
+			"involvedObject.name":      parts[1],
+		}.AsSelector().String()
+		list, err := ri.List(ctx, metav1.ListOptions{FieldSelector: selector})
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, list.Items...)
+	}
+
+	events := []EventInfo{}
+	for _, e := range items {
+		kind, _, _ := unstructured.NestedString(e.Object, "involvedObject", "kind")
+		objName, _, _ := unstructured.NestedString(e.Object, "involvedObject", "name")
+		info := EventInfo{Object: kind + "/" + objName}
+		info.Type, _, _ = unstructured.NestedString(e.Object, "type")
+		info.Reason, _, _ = unstructured.NestedString(e.Object, "reason")
+		info.Message, _, _ = unstructured.NestedString(e.Object, "message")
+		info.Count, _, _ = unstructured.NestedInt64(e.Object, "count")
+		for _, field := range []string{"lastTimestamp", "eventTime"} {
+			if ts, _, _ := unstructured.NestedString(e.Object, field); ts != "" {
+				if t, err := time.Parse(time.RFC3339, ts); err == nil {
+					info.LastSeen = t
+					break
+				}
+			}
+		}
+		events = append(events, info)
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].LastSeen.After(events[j].LastSeen) })
+	return events, nil
+}
+
+func sidecarStatus(deployment *unstructured.Unstructured, pods []unstructured.Unstructured) SidecarStatus {
+	status := SidecarStatus{Annotations: map[string]string{}, Pods: len(pods)}
+	annotations, _, _ := unstructured.NestedStringMap(deployment.Object, "spec", "template", "metadata", "annotations")
+	for key, value := range annotations {
+		if strings.HasPrefix(key, daprAnnotationPrefix) {
+			status.Annotations[key] = value
+		}
+	}
+	status.Enabled = annotations[daprAnnotationPrefix+"enabled"] == "true"
+	status.AppID = annotations[daprAnnotationPrefix+"app-id"]
+
+	for _, pod := range pods {
+		containers, _, _ := unstructured.NestedSlice(pod.Object, "spec", "containers")
+		for _, c := range containers {
+			if containerName, _ := c.(map[string]interface{})["name"].(string); containerName == DaprSidecarContainer {
+				status.InjectedPods++
+				break
+			}
+		}
+	}
+	return status
+}
+
+func sidecarProblems(name string, status SidecarStatus) []Problem {
+	resource := "Deployment/" + name
+	switch {
+	case !status.Enabled:
+		return []Problem{{SeverityWarning, resource, "SidecarDisabled", "dapr.io/enabled is not \"true\", the Dapr sidecar will not be injected"}}
+	case status.AppID == "":
+		return []Problem{{SeverityWarning, resource, "MissingAppID", "dapr.io/app-id is not set, Dapr will fall back to the pod name"}}
+	case status.InjectedPods < status.Pods:
+		return []Problem{{SeverityCritical, resource, "SidecarNotInjected", fmt.Sprintf("%d of %d pods run without the %s sidecar, check that the Dapr sidecar injector is running", status.Pods-status.InjectedPods, status.Pods, DaprSidecarContainer)}}
+	}
+	return nil
+}
+
+func deploymentProblems(app AppInfo) []Problem {
+	problems := []Problem{}
+	resource := "Deployment/" + app.Name
+	if app.ReadyReplicas < app.Replicas {
+		problems = append(problems, Problem{SeverityWarning, resource, "ReplicasNotReady", fmt.Sprintf("%d of %d replicas are ready", app.ReadyReplicas, app.Replicas)})
+	}
+	if app.Service == nil {
+		problems = append(problems, Problem{SeverityWarning, "Service/" + app.Name, "ServiceMissing", "app has no Service"})
+	} else if app.ExternalIP == "" {
+		problems = append(problems, Problem{SeverityInfo, "Service/" + app.Name, "ExternalIPPending", "load balancer has not assigned an external IP yet"})
+	}
+	return problems
+}
+
+// image pull errors, crash loops and other waiting containers of a pod
+func podProblems(pod *unstructured.Unstructured) []Problem {
+	problems := []Problem{}
+	resource := "Pod/" + pod.GetName()
+	if phase, _, _ := unstructured.NestedString(pod.Object, "status", "phase"); phase == "Pending" {
+		if scheduled := podCondition(pod, "PodScheduled"); scheduled != nil && scheduled["status"] == "False" {
+			message, _ := scheduled["message"].(string)
+			problems = append(problems, Problem{SeverityCritical, resource, "Unschedulable", message})
+		}
+	}
+
+	statuses, _, _ := unstructured.NestedSlice(pod.Object, "status", "containerStatuses")
+	for _, s := range statuses {
+		status, ok := s.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		container, _ := status["name"].(string)
+		reason, _, _ := unstructured.NestedString(status, "state", "waiting", "reason")
+		message, _, _ := unstructured.NestedString(status, "state", "waiting", "message")
+		switch {
+		case imagePullReasons[reason]:
+			image, _ := status["image"].(string)
+			problems = append(problems, Problem{SeverityCritical, resource, reason, fmt.Sprintf("container %s cannot pull image %s: %s", container, image, message)})
+		case reason == "CrashLoopBackOff":
+			lastReason, _, _ := unstructured.NestedString(status, "lastState", "terminated", "reason")
+			exitCode, _, _ := unstructured.NestedInt64(status, "lastState", "terminated", "exitCode")
+			restarts, _, _ := unstructured.NestedInt64(status, "restartCount")
+			problems = append(problems, Problem{SeverityCritical, resource, reason, fmt.Sprintf("container %s restarted %d times, last exit: %s (code %d)", container, restarts, lastReason, exitCode)})
+		case reason != "" && reason != "ContainerCreating" && reason != "PodInitializing":
+			problems = append(problems, Problem{SeverityWarning, resource, reason, fmt.Sprintf("container %s is waiting: %s", container, message)})
+		}
+	}
+	return problems
+}
+
+func podCondition(pod *unstructured.Unstructured, conditionType string) map[string]interface{} {
+	conditions, _, _ := unstructured.NestedSlice(pod.Object, "status", "conditions")
+	for _, c := range conditions {
+		if condition, ok := c.(map[string]interface{}); ok && condition["type"] == conditionType {
+			return condition
+		}
+	}
+	return nil
+}
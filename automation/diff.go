@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	utils "github.com/huaweicloud/dapr-automation/utils"
+	"github.com/pmezard/go-difflib/difflib"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/kubectl/pkg/util"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	DiffOperationCreate = "create"
+	DiffOperationPatch  = "patch"
+	DiffOperationNone   = "none"
+)
+
+type DiffRequest struct {
+	Namespace string                   `json:"namespace"` // Kubernetes Namespace override for namespaced resources
+	App       string                   `json:"app"`       // app name used for the ownership label, as apply would set it
+	Manifests []map[string]interface{} `json:"manifests"` // Kubernetes and Dapr manifests
+}
+
+// ManifestDiff is what applying one manifest would change
+type ManifestDiff struct {
+	Metadata  Metadata        `json:"metadata"`
+	Operation string          `json:"operation"`
+	PatchType string          `json:"patchType,omitempty"`
+	Patch     json.RawMessage `json:"patch,omitempty"` // with secret values redacted
+	Diff      string          `json:"diff"`            // unified diff of the live and merged objects as YAML
+}
+
+// compute what applying each manifest would change, nothing is persisted
+func (k *KubeClient) DiffManifests(ctx context.Context, req *DiffRequest, objs []*unstructured.Unstructured) (_ []ManifestDiff, err error) {
+	ctx, span := StartSpan(ctx, "DiffManifests")
+	defer func() { EndSpan(span, err) }()
+
+	if err := validateManifests(objs); err != nil {
+		return nil, err
+	}
+	diffs := []ManifestDiff{}
+	for _, u := range objs {
+		if req.App != "" {
+			labels := u.GetLabels()
+			if labels == nil {
+				labels = map[string]string{}
+			}
+			for key, value := range managedLabels(req.App) {
+				labels[key] = value.(string)
+			}
+			u.SetLabels(labels)
+		}
+		d, err := k.diff(ctx, u, req.Namespace)
+		if err != nil {
+			return diffs, fmt.Errorf("%s %s: %w", u.GetKind(), u.GetName(), err)
+		}
+		diffs = append(diffs, d)
+	}
+	return diffs, nil
+}
+
+func (k *KubeClient) diff(ctx context.Context, u *unstructured.Unstructured, namespaceOverride string) (ManifestDiff, error) {
+	// the helper only ever talks to the server in dry-run mode
+	info, helper, err := k.resourceInfo(u, ApplyOptions{NamespaceOverride: namespaceOverride, DryRun: true})
+	if err != nil {
+		return ManifestDiff{}, err
+	}
+	d := ManifestDiff{Metadata: metadataFor(u, info.Mapping.Resource.Resource)}
+
+	modified, err := util.GetModifiedConfiguration(u, true, unstructured.UnstructuredJSONScheme)
+	if err != nil {
+		return d, err
+	}
+
+	var live, merged runtime.Object
+	live, err = helper.Get(info.Namespace, info.Name)
+	switch {
+	case errors.IsNotFound(err):
+		live = nil
+		if err := util.CreateApplyAnnotation(u, unstructured.UnstructuredJSONScheme); err != nil {
+			return d, err
+		}
+		if merged, err = helper.Create(info.Namespace, true, u); err != nil {
+			return d, err
+		}
+		d.Operation = DiffOperationCreate
+	case err != nil:
+		return d, err
+	default:
+		patcher, err := utils.NewPatcher(info, helper)
+		if err != nil {
+			return d, err
+		}
+		patchType, patch, err := patcher.CalculatePatch(live, modified)
+		if err != nil {
+			return d, err
+		}
+		if string(patch) == "{}" {
+			merged = live
+			d.Operation = DiffOperationNone
+		} else {
+			if merged, err = helper.Patch(info.Namespace, info.Name, patchType, patch, nil); err != nil {
+				return d, err
+			}
+			d.Operation = DiffOperationPatch
+			d.PatchType = string(patchType)
+			d.Patch = redactPatch(d.Metadata.Kind, patch)
+		}
+	}
+
+	d.Diff, err = unifiedDiff(live, merged, d.Metadata)
+	LoggerFrom(ctx).Debug("diffed", "kind", d.Metadata.Kind, "name", d.Metadata.Name, "namespace", d.Metadata.Namespace, "operation", d.Operation)
+	return d, err
+}
+
+// unified diff of the two objects as YAML, live is nil for objects that do not exist yet
+func unifiedDiff(live, merged runtime.Object, meta Metadata) (string, error) {
+	from, to := diffObject(live), diffObject(merged)
+	redactObjects(meta.Kind, from, to)
+
+	fromYAML, err := diffYAML(from)
+	if err != nil {
+		return "", err
+	}
+	toYAML, err := diffYAML(to)
+	if err != nil {
+		return "", err
+	}
+	path := strings.Join([]string{meta.ApiVersion, meta.Kind, meta.Namespace, meta.Name}, "/")
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(fromYAML),
+		B:        difflib.SplitLines(toYAML),
+		FromFile: "live/" + path,
+		ToFile:   "merged/" + path,
+		Context:  3,
+	})
+}
+
+// object content without the fields that change on every write
+func diffObject(obj runtime.Object) map[string]interface{} {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok || u == nil {
+		return nil
+	}
+	content := u.DeepCopy().Object
+	unstructured.RemoveNestedField(content, "metadata", "managedFields")
+	return content
+}
+
+func diffYAML(content map[string]interface{}) (string, error) {
+	if content == nil {
+		return "", nil
+	}
+	b, err := yaml.Marshal(content)
+	return string(b), err
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func secretContent(data map[string]interface{}) map[string]interface{} {
+	content := map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata": map[string]interface{}{
+			"name":        "s",
+			"annotations": map[string]interface{}{lastAppliedAnnotation: `{"data":{"password":"c2VjcmV0"}}`},
+		},
+	}
+	if data != nil {
+		content["data"] = data
+	}
+	return content
+}
+
+func TestRedactObjects(t *testing.T) {
+	tests := []struct {
+		name             string
+		from, to         map[string]interface{}
+		wantFrom, wantTo map[string]interface{}
+	}{
+		{
+			name:     "unchanged values",
+			from:     map[string]interface{}{"a": "MQ=="},
+			to:       map[string]interface{}{"a": "MQ=="},
+			wantFrom: map[string]interface{}{"a": redactedValue},
+			wantTo:   map[string]interface{}{"a": redactedValue},
+		},
+		{
+			name:     "changed value",
+			from:     map[string]interface{}{"a": "MQ=="},
+			to:       map[string]interface{}{"a": "Mg=="},
+			wantFrom: map[string]interface{}{"a": redactedValue + " (before)"},
+			wantTo:   map[string]interface{}{"a": redactedValue + " (after)"},
+		},
+		{
+			name:     "added and removed values",
+			from:     map[string]interface{}{"old": "MQ=="},
+			to:       map[string]interface{}{"new": "Mg=="},
+			wantFrom: map[string]interface{}{"old": redactedValue + " (before)"},
+			wantTo:   map[string]interface{}{"new": redactedValue + " (after)"},
+		},
+		{
+			name:   "new Secret",
+			from:   nil,
+			to:     map[string]interface{}{"a": "MQ=="},
+			wantTo: map[string]interface{}{"a": redactedValue},
+		},
+		{
+			name:     "empty values stay visible",
+			from:     map[string]interface{}{"a": ""},
+			to:       map[string]interface{}{"a": ""},
+			wantFrom: map[string]interface{}{"a": ""},
+			wantTo:   map[string]interface{}{"a": ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var from map[string]interface{}
+			if tt.from != nil {
+				from = secretContent(tt.from)
+			}
+			to := secretContent(tt.to)
+			redactObjects("Secret", from, to)
+
+			gotFrom, _, _ := unstructured.NestedMap(from, "data")
+			gotTo, _, _ := unstructured.NestedMap(to, "data")
+			if !reflect.DeepEqual(gotFrom, tt.wantFrom) {
+				t.Errorf("from data = %v, want %v", gotFrom, tt.wantFrom)
+			}
+			if !reflect.DeepEqual(gotTo, tt.wantTo) {
+				t.Errorf("to data = %v, want %v", gotTo, tt.wantTo)
+			}
+			for _, content := range []map[string]interface{}{from, to} {
+				if content == nil {
+					continue
+				}
+				if _, found, _ := unstructured.NestedString(content, "metadata", "annotations", lastAppliedAnnotation); found {
+					t.Errorf("last applied configuration was not removed")
+				}
+			}
+		})
+	}
+}
+
+func TestUnifiedDiffMasksSecrets(t *testing.T) {
+	live := &unstructured.Unstructured{Object: secretContent(map[string]interface{}{"password": "b2xk"})}
+	merged := &unstructured.Unstructured{Object: secretContent(map[string]interface{}{"password": "bmV3"})}
+	diff, err := unifiedDiff(live, merged, Metadata{ApiVersion: "v1", Kind: "Secret", Namespace: "default", Name: "s"})
+	if err != nil {
+		t.Fatalf("unifiedDiff() error = %v", err)
+	}
+	for _, secret := range []string{"b2xk", "bmV3", "c2VjcmV0"} {
+		if strings.Contains(diff, secret) {
+			t.Errorf("diff contains secret value %q:\n%s", secret, diff)
+		}
+	}
+	if !strings.Contains(diff, redactedValue+" (after)") {
+		t.Errorf("diff does not show the change:\n%s", diff)
+	}
+}
+
+func componentContent(values ...string) *unstructured.Unstructured {
+	items := []interface{}{}
+	for i := 0; i+1 < len(values); i += 2 {
+		items = append(items, map[string]interface{}{"name": values[i], "value": values[i+1]})
+	}
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "dapr.io/v1alpha1",
+		"kind":       "Component",
+		"metadata": map[string]interface{}{
+			"name":        "statestore",
+			"annotations": map[string]interface{}{lastAppliedAnnotation: `{"spec":{"metadata":[{"name":"redisPassword","value":"hunter2"}]}}`},
+		},
+		"spec": map[string]interface{}{"metadata": items},
+	}}
+}
+
+func TestUnifiedDiffMasksComponentMetadata(t *testing.T) {
+	live := componentContent("redisHost", "redis:6379", "redisPassword", "hunter2")
+	merged := componentContent("redisHost", "redis:6380", "redisPassword", "hunter3")
+	diff, err := unifiedDiff(live, merged, Metadata{ApiVersion: "dapr.io/v1alpha1", Kind: "Component", Namespace: "default", Name: "statestore"})
+	if err != nil {
+		t.Fatalf("unifiedDiff() error = %v", err)
+	}
+	for _, secret := range []string{"hunter2", "hunter3"} {
+		if strings.Contains(diff, secret) {
+			t.Errorf("diff contains secret value %q:\n%s", secret, diff)
+		}
+	}
+	for _, want := range []string{redactedValue + " (after)", "redis:6380"} {
+		if !strings.Contains(diff, want) {
+			t.Errorf("diff does not contain %q:\n%s", want, diff)
+		}
+	}
+}
@@ -2,54 +2,173 @@ package main
 
 import (
 	"encoding/json"
-	"log"
+	"fmt"
+	"io/ioutil"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	utils "github.com/huaweicloud/dapr-automation/utils"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/yaml"
 )
 
 type AppCreateRequest struct {
-	Deployment map[string]interface{} `json:"deployment"` // Kubernetes App Deployment template
-	DCSConnect DCSConnectRequest      `json:"dcsConnect"`
+	Deployment      map[string]interface{} `json:"deployment"` // Kubernetes App Deployment template
+	DCSConnect      DCSConnectRequest      `json:"dcsConnect"`
+	ResourceVersion string                 `json:"resourceVersion"` // resourceVersion an existing Deployment must still have, any when empty
 }
 
 type AppDeleteRequest struct {
 	Namespace     string               `json:"namespace"`
 	Name          string               `json:"name"`
 	DCSDisconnect DCSDisconnectRequest `json:"dcsDisconnect"`
+	WaitOptions
+	DeletePolicy // preconditions are checked against the Deployment
 }
 
 type DCSDisconnectRequest struct {
 	Namespace string `json:"namespace"`
 	Name      string `json:"name"`
+	WaitOptions
+	DeletePolicy // preconditions are checked against the Component
+}
+
+type DeletePolicy struct {
+	PropagationPolicy string                `json:"propagationPolicy,omitempty"` // Foreground, Background or Orphan, the resource's default when empty
+	GracePeriod       *int                  `json:"gracePeriod,omitempty"`       // seconds, the resource's default when unset
+	Preconditions     *metav1.Preconditions `json:"preconditions,omitempty"`     // uid and resourceVersion the resource must still have
+}
+
+type WaitOptions struct {
+	Wait        bool `json:"wait"`        // wait until the deleted resources are gone
+	WaitTimeout int  `json:"waitTimeout"` // seconds, defaults to 60
+}
+
+var propagationPolicies = map[string]metav1.DeletionPropagation{
+	"foreground": metav1.DeletePropagationForeground,
+	"background": metav1.DeletePropagationBackground,
+	"orphan":     metav1.DeletePropagationOrphan,
+}
+
+// delete options of the policy, preconditions are left out for the resources they do not describe
+func (p DeletePolicy) deleteOptions(withPreconditions bool) (metav1.DeleteOptions, error) {
+	gracePeriod := utils.DefaultGracePeriod
+	if p.GracePeriod != nil {
+		if *p.GracePeriod < 0 {
+			return metav1.DeleteOptions{}, badRequestf("gracePeriod must not be negative")
+		}
+		gracePeriod = *p.GracePeriod
+	}
+	var policy *metav1.DeletionPropagation
+	if p.PropagationPolicy != "" {
+		value, ok := propagationPolicies[strings.ToLower(p.PropagationPolicy)]
+		if !ok {
+			return metav1.DeleteOptions{}, badRequestf("invalid propagationPolicy %q, expected Foreground, Background or Orphan", p.PropagationPolicy)
+		}
+		policy = &value
+	}
+	do := utils.DeleteOptionsWithPolicy(policy, gracePeriod)
+	if withPreconditions {
+		do.Preconditions = p.Preconditions
+	}
+	return do, nil
+}
+
+const defaultWaitTimeout = 60 * time.Second
+
+func (o WaitOptions) timeout() time.Duration {
+	if o.WaitTimeout <= 0 {
+		return defaultWaitTimeout
+	}
+	return time.Duration(o.WaitTimeout) * time.Second
 }
 
 type DCSConnectRequest struct {
-	DCSName    string `json:"dcsName"`    // Huaweicloud DCS name
-	Credential string `json:"credential"` // base64 encoded DCS connect password, leave empty if your DCS does not have one
-	AK         string `json:"ak"`         // base64 encoded AK
-	SK         string `json:"sk"`         // base64 encoded SK
-	Namespace  string `json:"namespace"`  // Kubernetes Namespace
-	Name       string `json:"name"`       // Dapr/Kubernetes resource name
+	DCSName         string `json:"dcsName"`         // Huaweicloud DCS name
+	Credential      string `json:"credential"`      // base64 encoded DCS connect password, leave empty if your DCS does not have one
+	AK              string `json:"ak"`              // base64 encoded AK
+	SK              string `json:"sk"`              // base64 encoded SK
+	Namespace       string `json:"namespace"`       // Kubernetes Namespace
+	Name            string `json:"name"`            // Dapr/Kubernetes resource name
+	ResourceVersion string `json:"resourceVersion"` // resourceVersion an existing Component must still have, any when empty
+}
+
+// copy of the request that is safe to log
+func (req AppCreateRequest) Redacted() AppCreateRequest {
+	req.DCSConnect = req.DCSConnect.Redacted()
+	req.Deployment = redactedDeployment(req.Deployment)
+	return req
+}
+
+// copy of a Deployment template with the inline env values of its containers masked,
+// envFrom and valueFrom only name their sources and are kept
+func redactedDeployment(deployment map[string]interface{}) map[string]interface{} {
+	if deployment == nil {
+		return nil
+	}
+	deployment = runtime.DeepCopyJSON(deployment)
+	for _, field := range []string{"containers", "initContainers"} {
+		containers, found, _ := unstructured.NestedSlice(deployment, "spec", "template", "spec", field)
+		if !found {
+			continue
+		}
+		for _, container := range containers {
+			c, ok := container.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			env, _ := c["env"].([]interface{})
+			for _, item := range env {
+				if e, ok := item.(map[string]interface{}); ok {
+					if value, ok := e["value"].(string); ok {
+						e["value"] = redact(value)
+					}
+				}
+			}
+		}
+		unstructured.SetNestedSlice(deployment, containers, "spec", "template", "spec", field)
+	}
+	return deployment
 }
 
-func (s *Server) HandleHeathCheck(w http.ResponseWriter, r *http.Request) {
-	log.Println("HandleHeathCheck")
+// copy of the request that is safe to log
+func (req DCSConnectRequest) Redacted() DCSConnectRequest {
+	req.Credential = redact(req.Credential)
+	req.AK = redact(req.AK)
+	req.SK = redact(req.SK)
+	return req
 }
 
 func (s *Server) HandleHelloWorld(w http.ResponseWriter, r *http.Request) {
-	log.Println("HandleHelloWorld")
+	LoggerFrom(r.Context()).Debug("HandleHelloWorld")
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Hello Dapr K8s!"))
 }
 
 // Deploy App on Dapr
 func (s *Server) HandleAppCreate(w http.ResponseWriter, r *http.Request) {
-	log.Println("HandleAppCreate")
+	ctx := r.Context()
 	var req AppCreateRequest
-	json.NewDecoder(r.Body).Decode(&req)
-	log.Println(req)
-	result, err := s.kubeClient.CreateAppDeploy(&req)
+	err := decodeRequest(r, &req)
+	if err != nil {
+		HandleBadRequest(w, r, err)
+		return
+	}
+	if version := ifMatchVersion(r); version != "" {
+		req.ResourceVersion = version
+	}
+	LoggerFrom(ctx).Info("HandleAppCreate", "request", req.Redacted())
+	RecordAuditRequest(ctx, req.Redacted())
+	result, err := s.client(r).CreateAppDeploy(ctx, &req)
 	if err != nil {
-		HandleInternalServerError(w, err)
+		HandleKubeError(w, r, err)
 	} else {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte(result))
@@ -58,17 +177,19 @@ func (s *Server) HandleAppCreate(w http.ResponseWriter, r *http.Request) {
 
 // Delete App on Dapr
 func (s *Server) HandleAppDelete(w http.ResponseWriter, r *http.Request) {
-	log.Println("HandleAppDelete")
+	ctx := r.Context()
 	var req AppDeleteRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
+	err := decodeRequest(r, &req)
 	if err != nil {
-		HandleInternalServerError(w, err)
+		HandleBadRequest(w, r, err)
+		return
 	}
-	log.Println(req)
+	LoggerFrom(ctx).Info("HandleAppDelete", "request", req)
+	RecordAuditRequest(ctx, req)
 
-	result, err := s.kubeClient.DeleteAppDeploy(&req)
+	result, err := s.client(r).DeleteAppDeploy(ctx, &req)
 	if err != nil {
-		HandleNotFound(w, err)
+		HandleKubeError(w, r, err)
 	} else {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte(result))
@@ -77,16 +198,21 @@ func (s *Server) HandleAppDelete(w http.ResponseWriter, r *http.Request) {
 
 // Connect DCS to Dapr
 func (s *Server) HandleDCSConnect(w http.ResponseWriter, r *http.Request) {
-	log.Println("HandleDCSConnect")
+	ctx := r.Context()
 	var req DCSConnectRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
+	err := decodeRequest(r, &req)
 	if err != nil {
-		HandleInternalServerError(w, err)
+		HandleBadRequest(w, r, err)
+		return
+	}
+	if version := ifMatchVersion(r); version != "" {
+		req.ResourceVersion = version
 	}
-	log.Println(req)
-	result, err := s.kubeClient.ConnectDCS(&req)
+	LoggerFrom(ctx).Info("HandleDCSConnect", "request", req.Redacted())
+	RecordAuditRequest(ctx, req.Redacted())
+	result, err := s.client(r).ConnectDCS(ctx, &req)
 	if err != nil {
-		HandleInternalServerError(w, err)
+		HandleKubeError(w, r, err)
 	} else {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("Dapr StateStore Connected, \n " + result))
@@ -95,30 +221,519 @@ func (s *Server) HandleDCSConnect(w http.ResponseWriter, r *http.Request) {
 
 // Disconnect DCS from Dapr
 func (s *Server) HandleDCSDisconnect(w http.ResponseWriter, r *http.Request) {
-	log.Println("HandleDCSDisconnect")
+	ctx := r.Context()
 	var req DCSDisconnectRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
+	err := decodeRequest(r, &req)
 	if err != nil {
-		HandleInternalServerError(w, err)
+		HandleBadRequest(w, r, err)
+		return
 	}
-	log.Println(req)
-	result, err := s.kubeClient.DisconnectDCS(&req)
+	LoggerFrom(ctx).Info("HandleDCSDisconnect", "request", req)
+	RecordAuditRequest(ctx, req)
+	result, err := s.client(r).DisconnectDCS(ctx, &req)
 	if err != nil {
-		HandleNotFound(w, err)
+		HandleKubeError(w, r, err)
 	} else {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte(result))
 	}
 }
 
-func HandleInternalServerError(w http.ResponseWriter, err error) {
-	log.Println(err)
+// List apps created through the API
+func (s *Server) HandleAppList(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	apps, err := s.client(r).ListApps(ctx, r.URL.Query().Get("namespace"))
+	if err != nil {
+		HandleInternalServerError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, apps)
+}
+
+// Get one app created through the API
+func (s *Server) HandleAppGet(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	app, err := s.client(r).GetApp(ctx, vars["namespace"], vars["name"])
+	if err != nil {
+		HandleKubeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, app)
+}
+
+// Update image, replicas, env, resources or Dapr annotations of an app
+func (s *Server) HandleAppUpdate(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	var req AppUpdateRequest
+	err := decodeRequest(r, &req)
+	if err != nil {
+		HandleBadRequest(w, r, err)
+		return
+	}
+	if version := ifMatchVersion(r); version != "" {
+		req.ResourceVersion = version
+	}
+	LoggerFrom(ctx).Info("HandleAppUpdate", "namespace", vars["namespace"], "name", vars["name"], "request", req.Redacted())
+	RecordAuditRequest(ctx, req.Redacted())
+
+	result, err := s.client(r).UpdateApp(ctx, vars["namespace"], vars["name"], &req)
+	if err != nil {
+		HandleKubeError(w, r, err)
+		return
+	}
+	setETag(w, result.ResourceVersion)
+	writeJSON(w, http.StatusOK, result)
+}
+
+// Rolling restart of an app's pods
+func (s *Server) HandleAppRestart(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	LoggerFrom(ctx).Info("HandleAppRestart", "namespace", vars["namespace"], "name", vars["name"])
+	result, err := s.client(r).RestartApp(ctx, vars["namespace"], vars["name"])
+	if err != nil {
+		HandleKubeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// Scale an app through the Deployment scale subresource
+func (s *Server) HandleAppScale(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	var req AppScaleRequest
+	err := decodeRequest(r, &req)
+	if err != nil {
+		HandleBadRequest(w, r, err)
+		return
+	}
+	LoggerFrom(ctx).Info("HandleAppScale", "namespace", vars["namespace"], "name", vars["name"], "request", req)
+	RecordAuditRequest(ctx, req)
+	result, err := s.client(r).ScaleApp(ctx, vars["namespace"], vars["name"], req.Replicas)
+	if err != nil {
+		HandleKubeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// Roll an app back to a previous revision
+func (s *Server) HandleAppRollback(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	var revision int64
+	if v := r.URL.Query().Get("revision"); v != "" {
+		var err error
+		if revision, err = strconv.ParseInt(v, 10, 64); err != nil || revision < 0 {
+			HandleBadRequest(w, r, fmt.Errorf("invalid revision %q", v))
+			return
+		}
+	}
+	LoggerFrom(ctx).Info("HandleAppRollback", "namespace", vars["namespace"], "name", vars["name"], "revision", revision)
+	result, err := s.client(r).RollbackApp(ctx, vars["namespace"], vars["name"], revision)
+	if err != nil {
+		HandleKubeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// List the rollout history of an app
+func (s *Server) HandleAppHistory(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	history, err := s.client(r).AppHistory(ctx, vars["namespace"], vars["name"])
+	if err != nil {
+		HandleKubeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, history)
+}
+
+// Diagnose why an app is not working
+func (s *Server) HandleAppDiagnose(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	diagnosis, err := s.client(r).DiagnoseApp(ctx, vars["namespace"], vars["name"])
+	if err != nil {
+		HandleKubeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, diagnosis)
+}
+
+// List Dapr Components
+func (s *Server) HandleComponentList(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	components, err := s.client(r).ListComponents(ctx, r.URL.Query().Get("namespace"))
+	if err != nil {
+		HandleInternalServerError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, components)
+}
+
+// Get one Dapr Component
+func (s *Server) HandleComponentGet(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	component, err := s.client(r).GetComponent(ctx, vars["namespace"], vars["name"])
+	if err != nil {
+		HandleKubeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, component)
+}
+
+// Apply a bundle of manifests for one app, either a JSON AppBundleRequest or
+// a YAML stream with name and namespace passed as query parameters
+func (s *Server) HandleAppBundle(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var req AppBundleRequest
+	var result BundleResult
+	var err error
+	if isYAMLRequest(r) {
+		objs, ok := decodeManifestsRequest(w, r)
+		if !ok {
+			return
+		}
+		q := r.URL.Query()
+		req.Name, req.Namespace = q.Get("name"), q.Get("namespace")
+		if err := parsePruneQuery(r, &req.DryRun, &req.Prune, &req.Cascade, &req.GracePeriod); err != nil {
+			HandleBadRequest(w, r, err)
+			return
+		}
+		LoggerFrom(ctx).Info("HandleAppBundle", "name", req.Name, "namespace", req.Namespace, "dryRun", req.DryRun, "prune", req.Prune, "manifests", len(objs))
+		RecordAuditRequest(ctx, bundleAuditRequest(req))
+		result, err = s.client(r).ApplyBundleObjects(ctx, &req, objs)
+	} else {
+		if err := decodeRequest(r, &req); err != nil {
+			HandleBadRequest(w, r, err)
+			return
+		}
+		LoggerFrom(ctx).Info("HandleAppBundle", "name", req.Name, "namespace", req.Namespace, "dryRun", req.DryRun, "prune", req.Prune, "manifests", len(req.Manifests))
+		RecordAuditRequest(ctx, bundleAuditRequest(req))
+		result, err = s.client(r).ApplyBundle(ctx, &req)
+	}
+
+	switch {
+	case errors.IsBadRequest(err):
+		HandleBadRequest(w, r, err)
+	case errors.IsConflict(err):
+		LoggerFrom(ctx).Warn("conflict", "error", err)
+		result.Error = err.Error()
+		writeJSON(w, http.StatusConflict, result)
+	case err != nil:
+		LoggerFrom(ctx).Error("bundle apply failed", "error", err)
+		result.Error = err.Error()
+		writeJSON(w, http.StatusInternalServerError, result)
+	default:
+		writeJSON(w, http.StatusOK, result)
+	}
+}
+
+// the bundle request without its manifests, which may hold secrets
+func bundleAuditRequest(req AppBundleRequest) AppBundleRequest {
+	req.Manifests = nil
+	return req
+}
+
+// Apply arbitrary manifests, given as JSON or as a YAML stream with options in the query
+func (s *Server) HandleApply(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var req ApplyManifestsRequest
+	var objs []*unstructured.Unstructured
+	if isYAMLRequest(r) {
+		var ok bool
+		if objs, ok = decodeManifestsRequest(w, r); !ok {
+			return
+		}
+		req.Namespace, req.App = r.URL.Query().Get("namespace"), r.URL.Query().Get("app")
+		if err := parsePruneQuery(r, &req.DryRun, &req.Prune, &req.Cascade, &req.GracePeriod); err != nil {
+			HandleBadRequest(w, r, err)
+			return
+		}
+	} else {
+		if err := decodeRequest(r, &req); err != nil {
+			HandleBadRequest(w, r, err)
+			return
+		}
+		var err error
+		if objs, err = manifestsToUnstructured(req.Manifests); err != nil {
+			HandleBadRequest(w, r, err)
+			return
+		}
+	}
+	if version := ifMatchVersion(r); version != "" {
+		// a single version can only guard a single object
+		if len(objs) != 1 {
+			HandleBadRequest(w, r, fmt.Errorf("If-Match requires exactly one manifest, set metadata.resourceVersion instead"))
+			return
+		}
+		objs[0].SetResourceVersion(version)
+	}
+	LoggerFrom(ctx).Info("HandleApply", "namespace", req.Namespace, "app", req.App, "dryRun", req.DryRun, "prune", req.Prune, "manifests", len(objs))
+	audit := req
+	audit.Manifests = nil
+	RecordAuditRequest(ctx, audit)
+
+	result, err := s.client(r).ApplyManifests(ctx, &req, objs)
+	writeManifestsResult(w, r, result, err)
+}
+
+// Delete the resources described by arbitrary manifests
+func (s *Server) HandleDelete(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var req DeleteManifestsRequest
+	var objs []*unstructured.Unstructured
+	if isYAMLRequest(r) {
+		var ok bool
+		if objs, ok = decodeManifestsRequest(w, r); !ok {
+			return
+		}
+		req.Namespace = r.URL.Query().Get("namespace")
+		var err error
+		if req.DryRun, err = queryBool(r, "dryRun"); err != nil {
+			HandleBadRequest(w, r, err)
+			return
+		}
+		if req.Wait, err = queryBool(r, "wait"); err != nil {
+			HandleBadRequest(w, r, err)
+			return
+		}
+		if v := r.URL.Query().Get("waitTimeout"); v != "" {
+			if req.WaitTimeout, err = strconv.Atoi(v); err != nil || req.WaitTimeout <= 0 {
+				HandleBadRequest(w, r, fmt.Errorf("invalid waitTimeout %q", v))
+				return
+			}
+		}
+	} else {
+		if err := decodeRequest(r, &req); err != nil {
+			HandleBadRequest(w, r, err)
+			return
+		}
+		var err error
+		if objs, err = manifestsToUnstructured(req.Manifests); err != nil {
+			HandleBadRequest(w, r, err)
+			return
+		}
+	}
+	LoggerFrom(ctx).Info("HandleDelete", "namespace", req.Namespace, "app", req.App, "dryRun", req.DryRun, "manifests", len(objs), "resources", len(req.Resources))
+	RecordAuditRequest(ctx, DeleteManifestsRequest{Namespace: req.Namespace, App: req.App, DryRun: req.DryRun, Resources: req.Resources, WaitOptions: req.WaitOptions})
+
+	result, err := s.client(r).DeleteManifests(ctx, &req, objs)
+	writeManifestsResult(w, r, result, err)
+}
+
+// Show what applying the manifests would change, given as JSON or as a YAML stream
+func (s *Server) HandleDiff(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var req DiffRequest
+	var objs []*unstructured.Unstructured
+	if isYAMLRequest(r) {
+		var ok bool
+		if objs, ok = decodeManifestsRequest(w, r); !ok {
+			return
+		}
+		req.Namespace, req.App = r.URL.Query().Get("namespace"), r.URL.Query().Get("app")
+	} else {
+		if err := decodeRequest(r, &req); err != nil {
+			HandleBadRequest(w, r, err)
+			return
+		}
+		var err error
+		if objs, err = manifestsToUnstructured(req.Manifests); err != nil {
+			HandleBadRequest(w, r, err)
+			return
+		}
+	}
+	LoggerFrom(ctx).Info("HandleDiff", "namespace", req.Namespace, "app", req.App, "manifests", len(objs))
+
+	diffs, err := s.client(r).DiffManifests(ctx, &req, objs)
+	if err != nil {
+		HandleKubeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, diffs)
+}
+
+func writeManifestsResult(w http.ResponseWriter, r *http.Request, result ManifestsResult, err error) {
+	switch {
+	case errors.IsBadRequest(err):
+		HandleBadRequest(w, r, err)
+	case errors.IsConflict(err):
+		LoggerFrom(r.Context()).Warn("conflict", "error", err)
+		result.Error = err.Error()
+		writeJSON(w, http.StatusConflict, result)
+	case err != nil:
+		LoggerFrom(r.Context()).Error("manifests failed", "error", err)
+		result.Error = err.Error()
+		writeJSON(w, http.StatusInternalServerError, result)
+	default:
+		writeJSON(w, http.StatusOK, result)
+	}
+}
+
+// dryRun, prune, cascade and gracePeriod query parameters of YAML apply requests
+func parsePruneQuery(r *http.Request, dryRun, prune *bool, cascade **bool, gracePeriod **int) error {
+	var err error
+	if *dryRun, err = queryBool(r, "dryRun"); err != nil {
+		return err
+	}
+	if *prune, err = queryBool(r, "prune"); err != nil {
+		return err
+	}
+	if v := r.URL.Query().Get("cascade"); v != "" {
+		c, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid cascade %q", v)
+		}
+		*cascade = &c
+	}
+	if v := r.URL.Query().Get("gracePeriod"); v != "" {
+		g, err := strconv.Atoi(v)
+		if err != nil || g < 0 {
+			return fmt.Errorf("invalid gracePeriod %q", v)
+		}
+		*gracePeriod = &g
+	}
+	return nil
+}
+
+// resourceVersion expected by the If-Match header, empty when absent or "*"
+func ifMatchVersion(r *http.Request) string {
+	version := strings.TrimSpace(r.Header.Get("If-Match"))
+	version = strings.TrimPrefix(version, "W/")
+	version = strings.Trim(version, `"`)
+	if version == "*" {
+		return ""
+	}
+	return version
+}
+
+// report the resourceVersion as the entity tag, so it can be sent back in If-Match
+func setETag(w http.ResponseWriter, resourceVersion string) {
+	if resourceVersion != "" {
+		w.Header().Set("ETag", `"`+resourceVersion+`"`)
+	}
+}
+
+// optional boolean query parameter, false when absent
+func queryBool(r *http.Request, name string) (bool, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q", name, v)
+	}
+	return b, nil
+}
+
+// Drop the cached discovery results, e.g. after installing CRDs
+func (s *Server) HandleDiscoveryInvalidate(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	LoggerFrom(ctx).Info("HandleDiscoveryInvalidate")
+	groups, err := s.client(r).InvalidateDiscovery(ctx)
+	if err != nil {
+		HandleInternalServerError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"status": "invalidated", "groups": groups})
+}
+
+// content types treated as YAML request bodies
+var yamlContentTypes = map[string]bool{
+	"application/yaml":   true,
+	"application/x-yaml": true,
+	"text/yaml":          true,
+	"text/x-yaml":        true,
+}
+
+func isYAMLRequest(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && yamlContentTypes[mediaType]
+}
+
+// decode a JSON or single document YAML request body into v
+func decodeRequest(r *http.Request, v interface{}) error {
+	if !isYAMLRequest(r) {
+		return json.NewDecoder(r.Body).Decode(v)
+	}
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	jsonBody, err := yaml.ToJSON(b)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(jsonBody, v)
+}
+
+// decode a multi-document manifest body, answering 400 with per-document errors when any document is invalid
+func decodeManifestsRequest(w http.ResponseWriter, r *http.Request) ([]*unstructured.Unstructured, bool) {
+	objs, manifestErrs, err := DecodeManifests(r.Body)
+	if err != nil {
+		HandleBadRequest(w, r, err)
+		return nil, false
+	}
+	if len(manifestErrs) > 0 {
+		LoggerFrom(r.Context()).Warn("invalid manifests", "errors", manifestErrs)
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"errors": manifestErrs})
+		return nil, false
+	}
+	return objs, true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// map Kubernetes API errors to the matching status code
+func HandleKubeError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.IsNotFound(err):
+		HandleNotFound(w, r, err)
+	case errors.IsBadRequest(err), errors.IsInvalid(err):
+		HandleBadRequest(w, r, err)
+	case errors.IsConflict(err):
+		HandleConflict(w, r, err)
+	default:
+		HandleInternalServerError(w, r, err)
+	}
+}
+
+func badRequestf(format string, args ...interface{}) error {
+	return errors.NewBadRequest(fmt.Sprintf(format, args...))
+}
+
+func HandleInternalServerError(w http.ResponseWriter, r *http.Request, err error) {
+	LoggerFrom(r.Context()).Error("internal server error", "error", err)
 	w.WriteHeader(http.StatusInternalServerError)
 	w.Write([]byte(err.Error()))
 }
 
-func HandleNotFound(w http.ResponseWriter, err error) {
-	log.Println(err)
+func HandleBadRequest(w http.ResponseWriter, r *http.Request, err error) {
+	LoggerFrom(r.Context()).Warn("bad request", "error", err)
+	w.WriteHeader(http.StatusBadRequest)
+	w.Write([]byte(err.Error()))
+}
+
+func HandleNotFound(w http.ResponseWriter, r *http.Request, err error) {
+	LoggerFrom(r.Context()).Warn("not found", "error", err)
 	w.WriteHeader(http.StatusNotFound)
 	w.Write([]byte(err.Error()))
 }
+
+func HandleConflict(w http.ResponseWriter, r *http.Request, err error) {
+	LoggerFrom(r.Context()).Warn("conflict", "error", err)
+	w.WriteHeader(http.StatusConflict)
+	w.Write([]byte(err.Error()))
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestIfMatchVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		ifMatch string
+		want    string
+	}{
+		{"absent", "", ""},
+		{"quoted", `"12345"`, "12345"},
+		{"unquoted", "12345", "12345"},
+		{"weak", `W/"12345"`, "12345"},
+		{"surrounding spaces", `  "12345" `, "12345"},
+		{"any", "*", ""},
+		{"quoted any", `"*"`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPatch, "/api/apps/default/app", nil)
+			if tt.ifMatch != "" {
+				r.Header.Set("If-Match", tt.ifMatch)
+			}
+			if got := ifMatchVersion(r); got != tt.want {
+				t.Errorf("ifMatchVersion(%q) = %q, want %q", tt.ifMatch, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetETag(t *testing.T) {
+	tests := []struct {
+		resourceVersion string
+		want            string
+	}{
+		{"12345", `"12345"`},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		setETag(w, tt.resourceVersion)
+		if got := w.Header().Get("ETag"); got != tt.want {
+			t.Errorf("setETag(%q) ETag = %q, want %q", tt.resourceVersion, got, tt.want)
+		}
+	}
+}
+
+func TestAppCreateRequestRedacted(t *testing.T) {
+	req := AppCreateRequest{
+		Deployment: map[string]interface{}{
+			"kind": "Deployment",
+			"spec": map[string]interface{}{"template": map[string]interface{}{"spec": map[string]interface{}{
+				"containers": []interface{}{map[string]interface{}{
+					"name": "app",
+					"env": []interface{}{
+						map[string]interface{}{"name": "DB_PASSWORD", "value": "hunter2"},
+						map[string]interface{}{"name": "EMPTY", "value": ""},
+						map[string]interface{}{"name": "FROM_SECRET", "valueFrom": map[string]interface{}{"secretKeyRef": map[string]interface{}{"name": "db", "key": "password"}}},
+					},
+					"envFrom": []interface{}{map[string]interface{}{"secretRef": map[string]interface{}{"name": "db"}}},
+				}},
+				"initContainers": []interface{}{map[string]interface{}{
+					"name": "init",
+					"env":  []interface{}{map[string]interface{}{"name": "TOKEN", "value": "abc"}},
+				}},
+			}}},
+		},
+		DCSConnect: DCSConnectRequest{SK: "sk-value"},
+	}
+
+	got := req.Redacted()
+	b, err := json.Marshal(got)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	for _, secret := range []string{"hunter2", "abc", "sk-value"} {
+		if strings.Contains(string(b), secret) {
+			t.Errorf("Redacted() = %s, contains %q", b, secret)
+		}
+	}
+	for _, kept := range []string{"DB_PASSWORD", `"value":""`, "secretKeyRef", "envFrom"} {
+		if !strings.Contains(string(b), kept) {
+			t.Errorf("Redacted() = %s, does not contain %q", b, kept)
+		}
+	}
+
+	// the request itself is left untouched
+	containers, _, _ := unstructured.NestedSlice(req.Deployment, "spec", "template", "spec", "containers")
+	env := containers[0].(map[string]interface{})["env"].([]interface{})
+	if value := env[0].(map[string]interface{})["value"]; value != "hunter2" {
+		t.Errorf("Redacted() changed the request, DB_PASSWORD = %v", value)
+	}
+}
+
+func TestDeleteOptions(t *testing.T) {
+	intPtr := func(i int) *int { return &i }
+	int64Ptr := func(i int64) *int64 { return &i }
+	policyPtr := func(p metav1.DeletionPropagation) *metav1.DeletionPropagation { return &p }
+	preconditions := &metav1.Preconditions{ResourceVersion: strPtr("12345")}
+
+	tests := []struct {
+		name              string
+		policy            DeletePolicy
+		withPreconditions bool
+		want              metav1.DeleteOptions
+		wantErr           bool
+	}{
+		{name: "defaults", want: metav1.DeleteOptions{}},
+		{name: "foreground", policy: DeletePolicy{PropagationPolicy: "Foreground"}, want: metav1.DeleteOptions{PropagationPolicy: policyPtr(metav1.DeletePropagationForeground)}},
+		{name: "background any case", policy: DeletePolicy{PropagationPolicy: "BACKGROUND"}, want: metav1.DeleteOptions{PropagationPolicy: policyPtr(metav1.DeletePropagationBackground)}},
+		{name: "orphan", policy: DeletePolicy{PropagationPolicy: "orphan"}, want: metav1.DeleteOptions{PropagationPolicy: policyPtr(metav1.DeletePropagationOrphan)}},
+		{name: "invalid policy", policy: DeletePolicy{PropagationPolicy: "cascade"}, wantErr: true},
+		{name: "grace period", policy: DeletePolicy{GracePeriod: intPtr(30)}, want: metav1.DeleteOptions{GracePeriodSeconds: int64Ptr(30)}},
+		{name: "zero grace period", policy: DeletePolicy{GracePeriod: intPtr(0)}, want: metav1.DeleteOptions{GracePeriodSeconds: int64Ptr(0)}},
+		{name: "negative grace period", policy: DeletePolicy{GracePeriod: intPtr(-1)}, wantErr: true},
+		{name: "preconditions", policy: DeletePolicy{Preconditions: preconditions}, withPreconditions: true, want: metav1.DeleteOptions{Preconditions: preconditions}},
+		{name: "preconditions not wanted", policy: DeletePolicy{Preconditions: preconditions}, want: metav1.DeleteOptions{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.policy.deleteOptions(tt.withPreconditions)
+			if tt.wantErr {
+				if !errors.IsBadRequest(err) {
+					t.Fatalf("deleteOptions() error = %v, want a bad request", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("deleteOptions() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("deleteOptions() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	region "github.com/huaweicloud/huaweicloud-sdk-go-v3/services/dcs/v2/region"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	HealthStatusOK     = "ok"
+	HealthStatusFailed = "failed"
+)
+
+// timeout for the optional cloud provider reachability check
+const cloudCheckTimeout = 5 * time.Second
+
+type HealthCheck struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+type HealthResponse struct {
+	Status string        `json:"status"`
+	Checks []HealthCheck `json:"checks"`
+}
+
+// Liveness probe, the process is alive as long as it can serve requests
+func (s *Server) HandleLiveness(w http.ResponseWriter, r *http.Request) {
+	resp := HealthResponse{
+		Status: HealthStatusOK,
+		Checks: []HealthCheck{{Name: "server", Status: HealthStatusOK}},
+	}
+	writeHealthResponse(w, resp)
+}
+
+// Readiness probe, the server is ready when the default cluster and its dependencies are reachable
+func (s *Server) HandleReadiness(w http.ResponseWriter, r *http.Request) {
+	checks := s.clusters.Default().ReadinessChecks()
+	if *checkCloudReadiness {
+		checks = append(checks, checkCloudProvider())
+	}
+
+	resp := HealthResponse{Status: HealthStatusOK, Checks: checks}
+	for _, c := range checks {
+		if c.Status != HealthStatusOK {
+			resp.Status = HealthStatusFailed
+			break
+		}
+	}
+	writeHealthResponse(w, resp)
+}
+
+// check API server, Dapr Component CRD and RESTMapper cache
+func (k *KubeClient) ReadinessChecks() []HealthCheck {
+	checks := []HealthCheck{}
+
+	// API server reachability through the discovery client
+	version, err := k.discovery.ServerVersion()
+	if err != nil {
+		checks = append(checks, newHealthCheck("apiserver", "", err))
+	} else {
+		checks = append(checks, newHealthCheck("apiserver", version.String(), nil))
+	}
+
+	// Dapr Component CRD must be installed for DCS connections
+	_, err = k.mapper.RESTMapping(schema.GroupKind{Group: "dapr.io", Kind: "Component"}, "v1alpha1")
+	checks = append(checks, newHealthCheck("daprComponentCRD", "dapr.io/v1alpha1 Component", err))
+
+	// RESTMapper must be able to resolve built-in resources from its cache
+	_, err = k.mapper.KindFor(schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"})
+	checks = append(checks, newHealthCheck("restMapper", fmt.Sprintf("cache fresh: %v", k.discovery.Fresh()), err))
+
+	return checks
+}
+
+// check the DCS endpoint of the configured region can be reached
+func checkCloudProvider() HealthCheck {
+	endpoint := region.ValueOf(dcsRegion).Endpoint
+	client := http.Client{Timeout: cloudCheckTimeout}
+	resp, err := client.Get(endpoint)
+	if err != nil {
+		return newHealthCheck("cloudProvider", "", err)
+	}
+	resp.Body.Close()
+	return newHealthCheck("cloudProvider", endpoint, nil)
+}
+
+func newHealthCheck(name, message string, err error) HealthCheck {
+	if err != nil {
+		return HealthCheck{Name: name, Status: HealthStatusFailed, Message: err.Error()}
+	}
+	return HealthCheck{Name: name, Status: HealthStatusOK, Message: message}
+}
+
+func writeHealthResponse(w http.ResponseWriter, resp HealthResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	if resp.Status == HealthStatusOK {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
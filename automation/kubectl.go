@@ -1,34 +1,96 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
 	"flag"
+	"fmt"
+	"io"
+	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	utils "github.com/huaweicloud/dapr-automation/utils"
+	"go.opentelemetry.io/otel/attribute"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/util/yaml"
 	"k8s.io/cli-runtime/pkg/resource"
+	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/discovery/cached/disk"
+	"k8s.io/client-go/discovery/cached/memory"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/restmapper"
-	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/util/homedir"
 	"k8s.io/kubectl/pkg/util"
 )
 
 type KubeClient struct {
-	c      dynamic.Interface
-	config *rest.Config
-	mapper *restmapper.DeferredDiscoveryRESTMapper
+	c         dynamic.Interface
+	config    *rest.Config // shared, never mutate it, copy it instead
+	discovery discovery.CachedDiscoveryInterface
+	mapper    *restmapper.DeferredDiscoveryRESTMapper
+	clients   *restClientCache
+	streams   *restClientCache // without client timeout, it would cut followed streams short
+	appLocks  *appLocks
+	watchHub  *WatchHub
+}
+
+// restClientCache keeps one REST client per GroupVersion, safe for concurrent use
+type restClientCache struct {
+	config rest.Config
+
+	mu      sync.Mutex
+	clients map[schema.GroupVersion]rest.Interface
+}
+
+func newRestClientCache(config rest.Config) *restClientCache {
+	return &restClientCache{config: config, clients: map[schema.GroupVersion]rest.Interface{}}
+}
+
+func (c *restClientCache) get(gv schema.GroupVersion) (rest.Interface, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if client, ok := c.clients[gv]; ok {
+		return client, nil
+	}
+	client, err := NewRestClient(c.config, gv)
+	if err != nil {
+		return nil, err
+	}
+	c.clients[gv] = client
+	return client, nil
+}
+
+// REST mapping of gvk, rediscovering once when the kind is unknown so newly installed CRDs are found
+func (k *KubeClient) restMapping(gvk schema.GroupVersionKind) (*meta.RESTMapping, error) {
+	mapping, err := k.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if meta.IsNoMatchError(err) {
+		k.mapper.Reset()
+		mapping, err = k.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	}
+	return mapping, err
+}
+
+// kind of a fully specified resource, rediscovering once when it is unknown
+func (k *KubeClient) kindFor(gvr schema.GroupVersionResource) (schema.GroupVersionKind, error) {
+	gvk, err := k.mapper.KindFor(gvr)
+	if meta.IsNoMatchError(err) {
+		k.mapper.Reset()
+		gvk, err = k.mapper.KindFor(gvr)
+	}
+	return gvk, err
 }
 
 type Metadata struct {
@@ -37,25 +99,13 @@ type Metadata struct {
 	ApiVersion string `json:"apiVersion"`
 	Resource   string `json:"resource"`
 	Kind       string `json:"kind"`
-}
 
-func NewKubeClient() (KubeClient, error) {
-	// Fetch local kubeconfig, requires kubectl to be used on the environment before
-	var kubeconfig *string
-	home := homedir.HomeDir()
-	if home != "" {
-		kubeconfig = flag.String("kubeconfig", filepath.Join(home, ".kube", "config"), "(optional) absolute path to the kubeconfig file")
-	} else {
-		kubeconfig = flag.String("kubeconfig", "", "absolute path to the kubeconfig file")
-	}
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
 
-	flag.Parse()
-
-	// Use the current context in kubeconfig
-	config, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
-	if err != nil {
-		return KubeClient{}, err
-	}
+// create a client for one cluster
+func NewKubeClient(config *rest.Config) (KubeClient, error) {
+	config = rest.CopyConfig(config)
 
 	// Create the dynamic client
 	config.Timeout = 180 * time.Second
@@ -64,75 +114,138 @@ func NewKubeClient() (KubeClient, error) {
 		return KubeClient{}, err
 	}
 	// Mapper
-	cacheDir := filepath.Join(home, ".kube", "cache")
-	httpCacheDir := filepath.Join(cacheDir, "http")
-	discoveryCacheDir := computeDiscoverCacheDir(filepath.Join(cacheDir, "discovery"), config.Host)
-
-	// DiscoveryClient queries API server about the resources
-	cdc, err := disk.NewCachedDiscoveryClientForConfig(config, discoveryCacheDir, httpCacheDir, 10*time.Minute)
+	cdc, err := newCachedDiscoveryClient(config)
 	if err != nil {
 		return KubeClient{}, err
 	}
 
 	mapper := restmapper.NewDeferredDiscoveryRESTMapper(cdc)
+	if *discoveryCacheInMemory && *discoveryCacheTTL > 0 {
+		// the memory cache never expires on its own
+		go func() {
+			for range time.Tick(*discoveryCacheTTL) {
+				mapper.Reset()
+			}
+		}()
+	}
+
+	streamConfig := *config
+	streamConfig.Timeout = 0
 
 	KubeClient := KubeClient{
-		c:      dynamicClient,
-		config: config,
-		mapper: mapper,
+		c:         dynamicClient,
+		config:    config,
+		discovery: cdc,
+		mapper:    mapper,
+		clients:   newRestClientCache(*config),
+		streams:   newRestClientCache(streamConfig),
+		appLocks:  newAppLocks(),
+		watchHub:  NewWatchHub(dynamicClient, mapper),
 	}
 
 	return KubeClient, err
 }
 
-func (k *KubeClient) ApplyWithNamespaceOverride(u *unstructured.Unstructured, namespaceOverride string) (Metadata, error) {
-	// Map template metadata
-	metadata := Metadata{}
-	gvk := u.GroupVersionKind()
+var (
+	discoveryCacheDir      = flag.String("discovery-cache-dir", defaultDiscoveryCacheDir(), "(optional) directory of the discovery and HTTP caches")
+	discoveryCacheTTL      = flag.Duration("discovery-cache-ttl", 10*time.Minute, "(optional) how long discovery results are cached")
+	discoveryCacheInMemory = flag.Bool("discovery-cache-memory", false, "(optional) keep the discovery cache in memory only, for read-only filesystems")
+)
 
-	restMapping, err := k.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
-	if err != nil {
-		return metadata, err
+func defaultDiscoveryCacheDir() string {
+	if home := homedir.HomeDir(); home != "" {
+		return filepath.Join(home, ".kube", "cache")
 	}
+	return filepath.Join(os.TempDir(), "dapr-automation", "cache")
+}
 
-	gvr := restMapping.Resource
-	gv := gvk.GroupVersion()
-	k.config.GroupVersion = &gv
+// DiscoveryClient queries API server about the resources, cached on disk or in memory as configured
+func newCachedDiscoveryClient(config *rest.Config) (discovery.CachedDiscoveryInterface, error) {
+	if *discoveryCacheInMemory {
+		dc, err := discovery.NewDiscoveryClientForConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		return memory.NewMemCacheClient(dc), nil
+	}
+	httpCacheDir := filepath.Join(*discoveryCacheDir, "http")
+	discoveryCacheDir := computeDiscoverCacheDir(filepath.Join(*discoveryCacheDir, "discovery"), config.Host)
+	return disk.NewCachedDiscoveryClientForConfig(config, discoveryCacheDir, httpCacheDir, *discoveryCacheTTL)
+}
 
-	// Create Kubernetes RESTClient
-	restClient, err := NewRestClient(*k.config, gv)
+// InvalidateDiscovery drops the cached discovery results, the next mapping rediscovers the API groups
+func (k *KubeClient) InvalidateDiscovery(ctx context.Context) (int, error) {
+	k.mapper.Reset()
+	groups, err := k.discovery.ServerGroups()
 	if err != nil {
-		return metadata, err
+		return 0, err
 	}
+	LoggerFrom(ctx).Info("discovery cache invalidated", "groups", len(groups.Groups))
+	return len(groups.Groups), nil
+}
 
-	helper := resource.NewHelper(restClient, restMapping)
-	// Override namespace
-	if namespaceOverride == "" {
-		namespace := u.GetNamespace()
-		if helper.NamespaceScoped && namespace == "" {
-			namespace = "default"
-			u.SetNamespace(namespace)
-		}
+// interval between checks while waiting for a deletion
+const deletionPollInterval = time.Second
+
+type ApplyOptions struct {
+	NamespaceOverride string // Kubernetes Namespace for namespaced resources, the manifest's own when empty
+	DryRun            bool   // server-side dry run, nothing is persisted
+	// resourceVersion the live object must have, the manifest's metadata.resourceVersion when empty
+	ResourceVersion string
+}
+
+func (k *KubeClient) ApplyWithNamespaceOverride(ctx context.Context, u *unstructured.Unstructured, namespaceOverride string) (Metadata, error) {
+	return k.Apply(ctx, u, ApplyOptions{NamespaceOverride: namespaceOverride})
+}
+
+func (k *KubeClient) Apply(ctx context.Context, u *unstructured.Unstructured, opts ApplyOptions) (Metadata, error) {
+	defer trackInFlight("apply")()
+	ctx, span := StartSpan(ctx, "KubeClient.Apply",
+		attribute.String("k8s.kind", u.GetKind()),
+		attribute.String("k8s.name", u.GetName()),
+		attribute.String("k8s.namespace", opts.NamespaceOverride),
+		attribute.Bool("k8s.dry_run", opts.DryRun))
+	metadata, err := k.apply(ctx, u, opts)
+	observeKubeOperation("apply", u.GroupVersionKind(), err)
+	EndSpan(span, err)
+
+	logger := LoggerFrom(ctx).With("kind", u.GetKind()).With("name", u.GetName()).With("namespace", u.GetNamespace()).With("dryRun", opts.DryRun)
+	if err != nil {
+		logger.Error("apply failed", "error", err)
 	} else {
-		if helper.NamespaceScoped {
-			u.SetNamespace(namespaceOverride)
-		}
+		logger.Info("applied")
 	}
+	return metadata, err
+}
 
-	info := &resource.Info{
-		Client:          restClient,
-		Mapping:         restMapping,
-		Namespace:       u.GetNamespace(),
-		Name:            u.GetName(),
-		Source:          "",
-		Object:          u,
-		ResourceVersion: restMapping.Resource.Version,
+func (k *KubeClient) apply(ctx context.Context, u *unstructured.Unstructured, opts ApplyOptions) (Metadata, error) {
+	// Map template metadata
+	metadata := Metadata{}
+	gvk := u.GroupVersionKind()
+
+	// the expected resourceVersion is a precondition, it must not end up in the last applied configuration
+	expectedVersion := opts.ResourceVersion
+	if expectedVersion == "" {
+		expectedVersion = u.GetResourceVersion()
+	}
+	u.SetResourceVersion("")
+
+	info, helper, err := k.resourceInfo(u, opts)
+	if err != nil {
+		return metadata, err
 	}
+	gvr := info.Mapping.Resource
 
 	patcher, err := utils.NewPatcher(info, helper)
 	if err != nil {
 		return metadata, err
 	}
+	patcher.OnConflictRetry = func() {
+		patchConflictRetriesTotal.WithLabelValues(gvk.Group, gvk.Version, gvk.Kind).Inc()
+	}
+	if expectedVersion != "" {
+		patcher.ResourceVersion = &expectedVersion
+	}
 
 	// Get the modified configuration of the object. Embed the result
 	// as an annotation in the modified configuration, so that it will appear
@@ -142,10 +255,14 @@ func (k *KubeClient) ApplyWithNamespaceOverride(u *unstructured.Unstructured, na
 		return metadata, err
 	}
 
+	operation := "patch"
 	if err := info.Get(); err != nil {
 		if !errors.IsNotFound(err) {
 			return metadata, err
 		}
+		if expectedVersion != "" {
+			return metadata, resourceVersionConflict(gvr.GroupResource(), info.Name, expectedVersion, "")
+		}
 
 		// Create the resource if it doesn't exist
 		// First, update the annotation used by kubectl apply
@@ -159,57 +276,237 @@ func (k *KubeClient) ApplyWithNamespaceOverride(u *unstructured.Unstructured, na
 			return metadata, err
 		}
 		info.Refresh(obj, true)
+		operation = "create"
 	}
 
-	_, patchedObject, err := patcher.Patch(info.Object, modified, info.Namespace, info.Name)
-	if err != nil {
-		return metadata, err
+	if operation == "patch" && expectedVersion != "" && info.ResourceVersion != expectedVersion {
+		return metadata, resourceVersionConflict(gvr.GroupResource(), info.Name, expectedVersion, info.ResourceVersion)
 	}
 
-	info.Refresh(patchedObject, true)
+	// a dry-run create persisted nothing to patch
+	var patch []byte
+	if !(opts.DryRun && operation == "create") {
+		patchInFlightDone := trackInFlight("patch")
+		var patchedObject runtime.Object
+		patch, patchedObject, err = patcher.Patch(ctx, info.Object, modified, info.Namespace, info.Name)
+		patchInFlightDone()
+		observeKubeOperation("patch", gvk, err)
+		if err != nil {
+			return metadata, err
+		}
+		info.Refresh(patchedObject, true)
+	}
 
 	metadata.Name = u.GetName()
 	metadata.Namespace = u.GetNamespace()
 	metadata.ApiVersion = gvr.Group + "/" + gvr.Version
 	metadata.Resource = gvr.Resource
 	metadata.Kind = gvk.Kind
+	metadata.ResourceVersion = info.ResourceVersion
+	if !opts.DryRun {
+		RecordAuditResource(ctx, metadata, operation, patch)
+	}
 
 	return metadata, nil
 }
 
-func (k *KubeClient) DeleteResourceByKindAndNameAndNamespace(kind, name, namespace string, do metav1.DeleteOptions) error {
-	gvk, err := k.mapper.KindFor(schema.GroupVersionResource{Resource: kind})
-	if err != nil {
-		return err
-	}
-
-	restMapping, err := k.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
-	if err != nil {
-		return err
-	}
+// delete one resource, gvk must carry the group and version so the kind resolves to exactly one resource
+func (k *KubeClient) DeleteResourceByKindAndNameAndNamespace(ctx context.Context, gvk schema.GroupVersionKind, name, namespace string, do metav1.DeleteOptions) (err error) {
+	defer trackInFlight("delete")()
+	ctx, span := StartSpan(ctx, "KubeClient.DeleteResourceByKindAndNameAndNamespace",
+		attribute.String("k8s.kind", gvk.Kind),
+		attribute.String("k8s.name", name),
+		attribute.String("k8s.namespace", namespace))
+	alreadyDeleted := false
+	defer func() {
+		EndSpan(span, err)
+		observeKubeOperation("delete", gvk, err)
+		logger := LoggerFrom(ctx).With("kind", gvk.Kind).With("name", name).With("namespace", namespace)
+		switch {
+		case err != nil:
+			logger.Error("delete failed", "error", err)
+		case alreadyDeleted:
+			logger.Info("already deleted")
+		default:
+			logger.Info("deleted")
+		}
+	}()
 
-	// Create Kubernetes RESTClient
-	restClient, err := NewRestClient(*k.config, gvk.GroupVersion())
+	restMapping, err := k.restMapping(gvk)
 	if err != nil {
 		return err
 	}
 
 	// Delete resource
-	helper := resource.NewHelper(restClient, restMapping)
-	if helper.NamespaceScoped {
+	if restMapping.Scope.Name() == meta.RESTScopeNameNamespace {
 		err = k.c.
 			Resource(restMapping.Resource).
 			Namespace(namespace).
-			Delete(context.TODO(), name, do)
+			Delete(ctx, name, do)
 	} else {
 		err = k.c.
 			Resource(restMapping.Resource).
-			Delete(context.TODO(), name, do)
+			Delete(ctx, name, do)
+	}
+
+	// deleting is idempotent, a resource that is already gone is what the caller wants, nothing is audited
+	if errors.IsNotFound(err) {
+		alreadyDeleted = true
+		return nil
 	}
+	if err == nil && len(do.DryRun) == 0 {
+		RecordAuditResource(ctx, Metadata{
+			Name:       name,
+			Namespace:  namespace,
+			ApiVersion: gvk.GroupVersion().String(),
+			Resource:   restMapping.Resource.Resource,
+			Kind:       gvk.Kind,
+		}, "delete", nil)
+	}
+	return err
+}
+
+// DeletionTimeoutError reports a resource that still exists after waiting for its deletion
+type DeletionTimeoutError struct {
+	Metadata
+	Finalizers []string
+}
+
+func (e *DeletionTimeoutError) Error() string {
+	msg := fmt.Sprintf("timed out waiting for %s %s/%s to be deleted", e.Kind, e.Namespace, e.Name)
+	if len(e.Finalizers) > 0 {
+		msg += fmt.Sprintf(", blocked by finalizers %s", strings.Join(e.Finalizers, ", "))
+	}
+	return msg
+}
 
+// poll until the resource is gone, reporting the finalizers still on it on timeout
+func (k *KubeClient) WaitForDeletion(ctx context.Context, gvk schema.GroupVersionKind, name, namespace string, timeout time.Duration) (err error) {
+	ctx, span := StartSpan(ctx, "KubeClient.WaitForDeletion",
+		attribute.String("k8s.kind", gvk.Kind),
+		attribute.String("k8s.name", name),
+		attribute.String("k8s.namespace", namespace))
+	defer func() { EndSpan(span, err) }()
+
+	restMapping, err := k.restMapping(gvk)
+	if err != nil {
+		return err
+	}
+	ri, err := k.resourceInterface(gvk, namespace)
+	if err != nil {
+		return err
+	}
+	var last *unstructured.Unstructured
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err = wait.PollImmediateUntil(deletionPollInterval, func() (bool, error) {
+		obj, err := ri.Get(waitCtx, name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		last = obj
+		return false, nil
+	}, waitCtx.Done())
+	if err == wait.ErrWaitTimeout && last != nil {
+		return &DeletionTimeoutError{Metadata: metadataFor(last, restMapping.Resource.Resource), Finalizers: last.GetFinalizers()}
+	}
 	return err
 }
 
+// resource to wait for with WaitForDeletions
+type deletionTarget struct {
+	gvk             schema.GroupVersionKind
+	name, namespace string
+}
+
+// wait for all the targets in parallel against one deadline, not one timeout per resource
+func (k *KubeClient) WaitForDeletions(ctx context.Context, targets []deletionTarget, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	errs := make([]error, len(targets))
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		go func(i int, t deletionTarget) {
+			defer wg.Done()
+			errs[i] = k.WaitForDeletion(ctx, t.gvk, t.name, t.namespace, timeout)
+		}(i, t)
+	}
+	wg.Wait()
+	return utilerrors.NewAggregate(errs)
+}
+
+// fail with a Conflict unless the live object of the manifest has the expected resourceVersion,
+// for workflows that must not change anything else before applying it
+func (k *KubeClient) checkResourceVersion(ctx context.Context, u *unstructured.Unstructured, namespaceOverride, expectedVersion string) error {
+	info, _, err := k.resourceInfo(u.DeepCopy(), ApplyOptions{NamespaceOverride: namespaceOverride})
+	if err != nil {
+		return err
+	}
+	gr := info.Mapping.Resource.GroupResource()
+	if err := info.Get(); err != nil {
+		if errors.IsNotFound(err) {
+			return resourceVersionConflict(gr, info.Name, expectedVersion, "")
+		}
+		return err
+	}
+	if info.ResourceVersion != expectedVersion {
+		return resourceVersionConflict(gr, info.Name, expectedVersion, info.ResourceVersion)
+	}
+	return nil
+}
+
+// Conflict for an object that does not have the expected resourceVersion, actual is empty when it does not exist
+func resourceVersionConflict(gr schema.GroupResource, name, expectedVersion, actualVersion string) error {
+	if actualVersion == "" {
+		return errors.NewConflict(gr, name, fmt.Errorf("expected resourceVersion %s, but the object does not exist", expectedVersion))
+	}
+	return errors.NewConflict(gr, name, fmt.Errorf("expected resourceVersion %s, but the object has %s", expectedVersion, actualVersion))
+}
+
+// resource info and helper for u, setting its namespace to the override or the default
+func (k *KubeClient) resourceInfo(u *unstructured.Unstructured, opts ApplyOptions) (*resource.Info, *resource.Helper, error) {
+	gvk := u.GroupVersionKind()
+	restMapping, err := k.restMapping(gvk)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Kubernetes RESTClient of the GroupVersion
+	restClient, err := k.clients.get(gvk.GroupVersion())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	helper := resource.NewHelper(restClient, restMapping).DryRun(opts.DryRun)
+	// Override namespace
+	if opts.NamespaceOverride == "" {
+		namespace := u.GetNamespace()
+		if helper.NamespaceScoped && namespace == "" {
+			namespace = "default"
+			u.SetNamespace(namespace)
+		}
+	} else {
+		if helper.NamespaceScoped {
+			u.SetNamespace(opts.NamespaceOverride)
+		}
+	}
+
+	info := &resource.Info{
+		Client:          restClient,
+		Mapping:         restMapping,
+		Namespace:       u.GetNamespace(),
+		Name:            u.GetName(),
+		Source:          "",
+		Object:          u,
+		ResourceVersion: restMapping.Resource.Version,
+	}
+	return info, helper, nil
+}
+
 func NewRestClient(restConfig rest.Config, gv schema.GroupVersion) (rest.Interface, error) {
 	restConfig.ContentConfig = resource.UnstructuredPlusDefaultContentConfig()
 	restConfig.GroupVersion = &gv
@@ -243,6 +540,66 @@ func ToUnstructured(manifest map[string]interface{}) (*unstructured.Unstructured
 	return JSONToUnstructured(b)
 }
 
+// ManifestError is a decoding failure of one document of a manifest stream
+type ManifestError struct {
+	Document int    `json:"document"` // 0-based index of the document in the stream
+	Error    string `json:"error"`
+}
+
+// DecodeManifests decodes a stream of JSON or YAML documents separated by "---",
+// empty documents are skipped and List kinds are expanded into their items
+func DecodeManifests(r io.Reader) ([]*unstructured.Unstructured, []ManifestError, error) {
+	objs := []*unstructured.Unstructured{}
+	manifestErrs := []ManifestError{}
+	reader := yaml.NewYAMLReader(bufio.NewReader(r))
+	for doc := 0; ; doc++ {
+		b, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if isEmptyDocument(b) {
+			continue
+		}
+
+		jsonDoc, err := yaml.ToJSON(b)
+		if err != nil {
+			manifestErrs = append(manifestErrs, ManifestError{Document: doc, Error: err.Error()})
+			continue
+		}
+		u, err := JSONToUnstructured(jsonDoc)
+		if err != nil {
+			manifestErrs = append(manifestErrs, ManifestError{Document: doc, Error: err.Error()})
+			continue
+		}
+		if u.IsList() {
+			err = u.EachListItem(func(item runtime.Object) error {
+				objs = append(objs, item.(*unstructured.Unstructured))
+				return nil
+			})
+			if err != nil {
+				manifestErrs = append(manifestErrs, ManifestError{Document: doc, Error: err.Error()})
+			}
+			continue
+		}
+		objs = append(objs, u)
+	}
+	return objs, manifestErrs, nil
+}
+
+// a document holding only whitespace, comments and separators
+func isEmptyDocument(b []byte) bool {
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && line != "---" && !strings.HasPrefix(line, "#") {
+			return false
+		}
+	}
+	return true
+}
+
 func JSONToUnstructured(jsonString []byte) (*unstructured.Unstructured, error) {
 	obj, _, err := unstructured.UnstructuredJSONScheme.Decode(jsonString, nil, nil)
 	if err != nil {
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeManifests(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		want      []string // Kind/name of the decoded objects
+		wantErrAt []int    // documents reported as invalid
+	}{
+		{
+			name: "multiple documents",
+			input: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+`,
+			want: []string{"ConfigMap/config", "Deployment/app"},
+		},
+		{
+			name: "empty and comment only documents",
+			input: `---
+# nothing here
+---
+
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: app
+---
+`,
+			want: []string{"Service/app"},
+		},
+		{
+			name: "list expansion",
+			input: `apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: Secret
+  metadata:
+    name: a
+- apiVersion: v1
+  kind: Secret
+  metadata:
+    name: b
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: c
+`,
+			want: []string{"Secret/a", "Secret/b", "ConfigMap/c"},
+		},
+		{
+			name: "invalid documents are reported and skipped",
+			input: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: ok
+---
+metadata:
+  name: no-kind
+---
+key: [unclosed
+`,
+			want:      []string{"ConfigMap/ok"},
+			wantErrAt: []int{1, 2},
+		},
+		{
+			name:  "empty input",
+			input: "",
+			want:  []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objs, manifestErrs, err := DecodeManifests(strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatalf("DecodeManifests() error = %v", err)
+			}
+			got := make([]string, len(objs))
+			for i, u := range objs {
+				got[i] = u.GetKind() + "/" + u.GetName()
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DecodeManifests() objects = %v, want %v", got, tt.want)
+			}
+			errAt := []int{}
+			for _, e := range manifestErrs {
+				errAt = append(errAt, e.Document)
+			}
+			if tt.wantErrAt == nil {
+				tt.wantErrAt = []int{}
+			}
+			if !reflect.DeepEqual(errAt, tt.wantErrAt) {
+				t.Errorf("DecodeManifests() errors at documents %v, want %v (%v)", errAt, tt.wantErrAt, manifestErrs)
+			}
+		})
+	}
+}
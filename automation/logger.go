@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var logLevelNames = map[LogLevel]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+const (
+	// header used to receive and return the request ID
+	RequestIDHeader = "X-Request-ID"
+	// incoming request IDs longer than this are replaced with a generated one
+	maxRequestIDLength = 128
+	// replacement for secret values in logs
+	redactedValue = "[REDACTED]"
+)
+
+var logLevelFlag = flag.String("log-level", "info", "(optional) minimum log level: debug, info, warn or error")
+
+// Logger writes one JSON object per line with a level, a message and structured fields
+type Logger struct {
+	mu     *sync.Mutex
+	out    io.Writer
+	fields map[string]interface{}
+}
+
+var defaultLogger = &Logger{
+	mu:     &sync.Mutex{},
+	out:    os.Stderr,
+	fields: map[string]interface{}{},
+}
+
+type loggerKey struct{}
+
+// return a child logger with an additional field
+func (l *Logger) With(key string, value interface{}) *Logger {
+	fields := make(map[string]interface{}, len(l.fields)+1)
+	for k, v := range l.fields {
+		fields[k] = v
+	}
+	fields[key] = value
+	return &Logger{mu: l.mu, out: l.out, fields: fields}
+}
+
+func (l *Logger) Debug(msg string, keyvals ...interface{}) { l.log(LevelDebug, msg, keyvals) }
+func (l *Logger) Info(msg string, keyvals ...interface{})  { l.log(LevelInfo, msg, keyvals) }
+func (l *Logger) Warn(msg string, keyvals ...interface{})  { l.log(LevelWarn, msg, keyvals) }
+func (l *Logger) Error(msg string, keyvals ...interface{}) { l.log(LevelError, msg, keyvals) }
+
+// log at error level and exit
+func (l *Logger) Fatal(msg string, keyvals ...interface{}) {
+	l.log(LevelError, msg, keyvals)
+	os.Exit(1)
+}
+
+func (l *Logger) log(level LogLevel, msg string, keyvals []interface{}) {
+	if level < parseLogLevel(*logLevelFlag) {
+		return
+	}
+
+	entry := make(map[string]interface{}, len(l.fields)+len(keyvals)/2+3)
+	for k, v := range l.fields {
+		entry[k] = v
+	}
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		value := keyvals[i+1]
+		if err, ok := value.(error); ok {
+			value = err.Error()
+		}
+		entry[fmt.Sprint(keyvals[i])] = value
+	}
+	entry["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	entry["level"] = logLevelNames[level]
+	entry["msg"] = msg
+
+	b, err := json.Marshal(entry)
+	if err != nil {
+		b, _ = json.Marshal(map[string]interface{}{"level": "error", "msg": "failed to marshal log entry", "error": err.Error()})
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(append(b, '\n'))
+}
+
+func parseLogLevel(name string) LogLevel {
+	for level, n := range logLevelNames {
+		if n == name {
+			return level
+		}
+	}
+	return LevelInfo
+}
+
+// attach a logger to the context
+func WithLogger(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// fetch the request scoped logger, falls back to the default logger
+func LoggerFrom(ctx context.Context) *Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*Logger); ok {
+		return l
+	}
+	return defaultLogger
+}
+
+// return the ID of the request the context belongs to, empty if there is none
+func RequestIDFrom(ctx context.Context) string {
+	id, _ := LoggerFrom(ctx).fields["requestId"].(string)
+	return id
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// assign every request an ID, return it in the response and log the request outcome
+func requestLoggingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		requestID := r.Header.Get(RequestIDHeader)
+		if requestID == "" || len(requestID) > maxRequestIDLength {
+			requestID = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, requestID)
+
+		logger := defaultLogger.With("requestId", requestID)
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(WithLogger(r.Context(), logger)))
+
+		logger.Info("request completed",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"durationMs", time.Since(start).Milliseconds())
+	})
+}
+
+func redact(value string) string {
+	if value == "" {
+		return ""
+	}
+	return redactedValue
+}
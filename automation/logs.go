@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// name of the sidecar container injected by Dapr
+	DaprSidecarContainer = "daprd"
+	// longest log line forwarded, longer lines are split
+	maxLogLineLength = 1024 * 1024
+)
+
+var podGVK = schema.GroupVersionKind{Group: "", Version: "v1", Kind: "Pod"}
+
+type AppLogsRequest struct {
+	Container string         // only stream this container, all containers including daprd when empty
+	Follow    bool           // keep streaming new lines
+	TailLines *int64         // number of lines from the end of each log
+	Since     *time.Duration // only lines newer than this
+}
+
+// LogLine is one log line tagged with its origin
+type LogLine struct {
+	Pod       string `json:"pod"`
+	Container string `json:"container"`
+	Line      string `json:"line"`
+}
+
+// stream the logs of every pod of the app's Deployment to lines until ctx is done or all logs end
+func (k *KubeClient) StreamAppLogs(ctx context.Context, deployment *unstructured.Unstructured, req AppLogsRequest, lines chan<- LogLine) error {
+	namespace := deployment.GetNamespace()
+	pods, err := k.deploymentPods(ctx, deployment)
+	if err != nil {
+		return err
+	}
+
+	restClient, err := k.streams.get(podGVK.GroupVersion())
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	for _, pod := range pods {
+		containers, _, _ := unstructured.NestedSlice(pod.Object, "spec", "containers")
+		for _, c := range containers {
+			container, _ := c.(map[string]interface{})["name"].(string)
+			if req.Container != "" && req.Container != container {
+				continue
+			}
+
+			request := restClient.Get().
+				Namespace(namespace).
+				Resource("pods").
+				Name(pod.GetName()).
+				SubResource("log").
+				Param("container", container).
+				Param("follow", strconv.FormatBool(req.Follow))
+			if req.TailLines != nil {
+				request = request.Param("tailLines", strconv.FormatInt(*req.TailLines, 10))
+			}
+			if req.Since != nil {
+				// rounded up, sinceSeconds must be positive
+				request = request.Param("sinceSeconds", strconv.FormatInt(int64(math.Ceil(req.Since.Seconds())), 10))
+			}
+
+			wg.Add(1)
+			go func(podName, container string) {
+				defer wg.Done()
+				stream, err := request.Stream(ctx)
+				if err != nil {
+					sendLogLine(ctx, lines, LogLine{Pod: podName, Container: container, Line: "error: " + err.Error()})
+					return
+				}
+				defer stream.Close()
+				scanLogLines(ctx, stream, podName, container, lines)
+			}(pod.GetName(), container)
+		}
+	}
+	wg.Wait()
+	return nil
+}
+
+// pods selected by the Deployment
+func (k *KubeClient) deploymentPods(ctx context.Context, deployment *unstructured.Unstructured) ([]unstructured.Unstructured, error) {
+	matchLabels, _, err := unstructured.NestedStringMap(deployment.Object, "spec", "selector", "matchLabels")
+	if err != nil {
+		return nil, err
+	}
+	ri, err := k.resourceInterface(podGVK, deployment.GetNamespace())
+	if err != nil {
+		return nil, err
+	}
+	list, err := ri.List(ctx, metav1.ListOptions{LabelSelector: labels.SelectorFromSet(matchLabels).String()})
+	if err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+func scanLogLines(ctx context.Context, r io.Reader, pod, container string, lines chan<- LogLine) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLogLineLength)
+	for scanner.Scan() {
+		if !sendLogLine(ctx, lines, LogLine{Pod: pod, Container: container, Line: scanner.Text()}) {
+			return
+		}
+	}
+}
+
+// send a line unless ctx is done, reports whether the line was sent
+func sendLogLine(ctx context.Context, lines chan<- LogLine, line LogLine) bool {
+	select {
+	case lines <- line:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Stream app logs as Server-Sent Events or chunked text
+func (s *Server) HandleAppLogs(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	req, err := parseAppLogsRequest(r)
+	if err != nil {
+		HandleBadRequest(w, r, err)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		HandleInternalServerError(w, r, fmt.Errorf("streaming is not supported"))
+		return
+	}
+	sse := r.URL.Query().Get("format") == "sse" || strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+	LoggerFrom(ctx).Info("HandleAppLogs", "namespace", vars["namespace"], "name", vars["name"], "container", req.Container, "follow", req.Follow, "sse", sse)
+
+	// fail before writing headers when the app does not exist
+	client := s.client(r)
+	deployment, err := client.getManagedDeployment(ctx, vars["namespace"], vars["name"])
+	if err != nil {
+		HandleKubeError(w, r, err)
+		return
+	}
+
+	lines := make(chan LogLine)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- client.StreamAppLogs(ctx, deployment, req, lines)
+		close(lines)
+	}()
+
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for line := range lines {
+		if sse {
+			b, _ := json.Marshal(line)
+			fmt.Fprintf(w, "event: log\ndata: %s\n\n", b)
+		} else {
+			fmt.Fprintf(w, "[%s/%s] %s\n", line.Pod, line.Container, line.Line)
+		}
+		flusher.Flush()
+	}
+	if err := <-errc; err != nil {
+		LoggerFrom(ctx).Error("log stream failed", "error", err)
+		if sse {
+			fmt.Fprintf(w, "event: error\ndata: %q\n\n", err.Error())
+		}
+	}
+}
+
+func parseAppLogsRequest(r *http.Request) (AppLogsRequest, error) {
+	q := r.URL.Query()
+	req := AppLogsRequest{Container: q.Get("container")}
+	if v := q.Get("follow"); v != "" {
+		follow, err := strconv.ParseBool(v)
+		if err != nil {
+			return req, fmt.Errorf("invalid follow %q", v)
+		}
+		req.Follow = follow
+	}
+	if v := q.Get("tailLines"); v != "" {
+		tail, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || tail < 0 {
+			return req, fmt.Errorf("invalid tailLines %q", v)
+		}
+		req.TailLines = &tail
+	}
+	if v := q.Get("since"); v != "" {
+		since, err := time.ParseDuration(v)
+		if err != nil || since < time.Second {
+			return req, fmt.Errorf("invalid since %q, expected a duration of at least 1s such as 10m", v)
+		}
+		req.Since = &since
+	}
+	return req, nil
+}
@@ -1,22 +1,32 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
-	"log"
 	"net/http"
 	"sync"
 
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/cors"
 )
 
+var checkCloudReadiness = flag.Bool("readyz-cloud", false, "(optional) include Huaweicloud DCS endpoint reachability in readiness checks")
+
 func main() {
 	var wg sync.WaitGroup
 
 	s, err := NewServer(&wg)
 	if err != nil {
-		log.Fatal(err)
+		defaultLogger.Fatal("failed to create server", "error", err)
+	}
+	shutdownTracing, err := InitTracing(context.Background())
+	if err != nil {
+		defaultLogger.Fatal("failed to initialize tracing", "error", err)
 	}
+	defer shutdownTracing(context.Background())
+
 	if s != nil {
 		go s.WithMuxer().Start()
 	}
@@ -26,20 +36,26 @@ func main() {
 }
 
 type Server struct {
-	wg         *sync.WaitGroup
-	muxer      *mux.Router
-	kubeClient *KubeClient
+	wg       *sync.WaitGroup
+	muxer    *mux.Router
+	clusters *Clusters
+	audit    AuditSink
 }
 
 // create a server struct, input is wait group
 func NewServer(wg *sync.WaitGroup) (*Server, error) {
-	client, err := NewKubeClient()
+	clusters, err := NewClusters()
+	if err != nil {
+		return nil, err
+	}
+	audit, err := NewAuditSink()
 	if err != nil {
 		return nil, err
 	}
 	s := &Server{
-		wg:         wg,
-		kubeClient: &client,
+		wg:       wg,
+		clusters: clusters,
+		audit:    audit,
 	}
 
 	// add one job to wait group
@@ -50,32 +66,70 @@ func NewServer(wg *sync.WaitGroup) (*Server, error) {
 // set up endpoint with muxer
 func (s *Server) WithMuxer() *Server {
 	s.muxer = mux.NewRouter()
+	s.muxer.Use(requestLoggingMiddleware)
+	s.muxer.Use(tracingMiddleware)
+	s.muxer.Use(metricsMiddleware)
 	// health check
-	s.muxer.HandleFunc("/health", s.HandleHeathCheck).Methods("GET")
+	s.muxer.HandleFunc("/health", s.HandleLiveness).Methods("GET")
+	s.muxer.HandleFunc("/healthz", s.HandleLiveness).Methods("GET")
+	s.muxer.HandleFunc("/readyz", s.HandleReadiness).Methods("GET")
+	// prometheus metrics
+	s.muxer.Handle("/metrics", promhttp.Handler()).Methods("GET")
 
 	// create a muxer, all other rest api are under this muxer
 	subRouter := s.muxer.PathPrefix("/api").Subrouter()
-	subRouter.HandleFunc("/", s.HandleHelloWorld).Methods("GET")
-	subRouter.HandleFunc("/app/create", s.HandleAppCreate).Methods("POST")
-	subRouter.HandleFunc("/app/delete", s.HandleAppDelete).Methods("POST")
-	subRouter.HandleFunc("/dcs/connect", s.HandleDCSConnect).Methods("POST")
-	subRouter.HandleFunc("/dcs/disconnect", s.HandleDCSDisconnect).Methods("POST")
+	subRouter.Use(concurrencyLimitMiddleware())
+	subRouter.Use(s.clusterMiddleware)
+	subRouter.HandleFunc("/clusters", s.HandleClusters).Methods("GET")
+	// every API is also served per cluster under /api/clusters/{cluster}
+	s.apiRoutes(subRouter.PathPrefix("/clusters/{cluster}").Subrouter())
+	s.apiRoutes(subRouter)
 
 	return s
 }
 
 func (s *Server) Start() {
-	log.Println("Dapr Automation Server started")
+	defaultLogger.Info("Dapr Automation Server started")
 
 	c := cors.New(cors.Options{
 		AllowedOrigins: []string{"*"},
+		AllowedMethods: []string{http.MethodGet, http.MethodPost, http.MethodPatch, http.MethodDelete},
+		AllowedHeaders: []string{"Origin", "Accept", "Content-Type", "X-Requested-With", RequestIDHeader, "If-Match", ClusterHeader},
+		ExposedHeaders: []string{RequestIDHeader, "ETag", "Retry-After"},
 	})
 
 	handler := c.Handler(s.muxer)
 
 	err := http.ListenAndServe(fmt.Sprintf("%s:%d", "0.0.0.0", 3000), handler)
 	if err != nil {
-		log.Fatal(err)
+		defaultLogger.Fatal("server stopped", "error", err)
 	}
 	s.wg.Done()
 }
+
+// register the cluster scoped API on the router
+func (s *Server) apiRoutes(router *mux.Router) {
+	router.HandleFunc("/", s.HandleHelloWorld).Methods("GET")
+	router.HandleFunc("/app/create", s.audited(s.HandleAppCreate)).Methods("POST")
+	router.HandleFunc("/app/delete", s.audited(s.HandleAppDelete)).Methods("POST")
+	router.HandleFunc("/app/bundle", s.audited(s.HandleAppBundle)).Methods("POST")
+	router.HandleFunc("/apply", s.audited(s.HandleApply)).Methods("POST")
+	router.HandleFunc("/delete", s.audited(s.HandleDelete)).Methods("POST")
+	router.HandleFunc("/diff", s.HandleDiff).Methods("POST")
+	router.HandleFunc("/dcs/connect", s.audited(s.HandleDCSConnect)).Methods("POST")
+	router.HandleFunc("/dcs/disconnect", s.audited(s.HandleDCSDisconnect)).Methods("POST")
+	router.HandleFunc("/audit", s.HandleAuditQuery).Methods("GET")
+	router.HandleFunc("/admin/discovery/invalidate", s.audited(s.HandleDiscoveryInvalidate)).Methods("POST")
+	router.HandleFunc("/apps", s.HandleAppList).Methods("GET")
+	router.HandleFunc("/apps/{namespace}/{name}", s.HandleAppGet).Methods("GET")
+	router.HandleFunc("/apps/{namespace}/{name}", s.audited(s.HandleAppUpdate)).Methods("PATCH")
+	router.HandleFunc("/apps/{namespace}/{name}/restart", s.audited(s.HandleAppRestart)).Methods("POST")
+	router.HandleFunc("/apps/{namespace}/{name}/scale", s.audited(s.HandleAppScale)).Methods("POST")
+	router.HandleFunc("/apps/{namespace}/{name}/rollback", s.audited(s.HandleAppRollback)).Methods("POST")
+	router.HandleFunc("/apps/{namespace}/{name}/history", s.HandleAppHistory).Methods("GET")
+	router.HandleFunc("/apps/{namespace}/{name}/logs", s.HandleAppLogs).Methods("GET")
+	router.HandleFunc("/apps/{namespace}/{name}/diagnose", s.HandleAppDiagnose).Methods("GET")
+	router.HandleFunc("/watch", s.HandleWatch).Methods("GET")
+	router.HandleFunc("/components", s.HandleComponentList).Methods("GET")
+	router.HandleFunc("/components/{namespace}/{name}", s.HandleComponentGet).Methods("GET")
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+type ApplyManifestsRequest struct {
+	Namespace string                   `json:"namespace"` // Kubernetes Namespace override for namespaced resources
+	DryRun    bool                     `json:"dryRun"`    // server-side dry run, nothing is persisted
+	Prune     bool                     `json:"prune"`     // delete resources of the app that are not in the manifests
+	App       string                   `json:"app"`       // app name used for the ownership label, required to prune
+	Manifests []map[string]interface{} `json:"manifests"` // Kubernetes and Dapr manifests, any order
+
+	Cascade     *bool `json:"cascade,omitempty"`     // prune dependents too, default true
+	GracePeriod *int  `json:"gracePeriod,omitempty"` // prune grace period in seconds, default of the resource when unset
+}
+
+type DeleteManifestsRequest struct {
+	Namespace string                   `json:"namespace"` // Kubernetes Namespace override for namespaced resources
+	App       string                   `json:"app"`       // app whose lock is held in each target namespace, none when empty
+	DryRun    bool                     `json:"dryRun"`    // server-side dry run, nothing is deleted
+	Manifests []map[string]interface{} `json:"manifests"` // only apiVersion, kind and metadata are used
+	Resources []DeleteTarget           `json:"resources"` // resources to delete besides the manifests
+	WaitOptions
+}
+
+// DeleteTarget identifies the resources to delete by apiVersion and kind, or by group, version and resource,
+// and by name or label selector
+type DeleteTarget struct {
+	APIVersion    string `json:"apiVersion,omitempty"`
+	Kind          string `json:"kind,omitempty"`
+	Group         string `json:"group,omitempty"`
+	Version       string `json:"version,omitempty"`
+	Resource      string `json:"resource,omitempty"`
+	Namespace     string `json:"namespace,omitempty"`
+	Name          string `json:"name,omitempty"`
+	LabelSelector string `json:"labelSelector,omitempty"`
+}
+
+// a delete target resolved to exactly one kind
+type resolvedTarget struct {
+	DeleteTarget
+	gvk     schema.GroupVersionKind
+	mapping *meta.RESTMapping
+}
+
+type ManifestsResult struct {
+	DryRun  bool       `json:"dryRun"`
+	Applied []Metadata `json:"applied,omitempty"`
+	Deleted []Metadata `json:"deleted,omitempty"`
+	Pruned  []Metadata `json:"pruned,omitempty"`
+	Error   string     `json:"error,omitempty"`
+}
+
+func manifestsToUnstructured(manifests []map[string]interface{}) ([]*unstructured.Unstructured, error) {
+	objs := []*unstructured.Unstructured{}
+	for i, manifest := range manifests {
+		u, err := ToUnstructured(manifest)
+		if err != nil {
+			return nil, badRequestf("manifest %d: %v", i, err)
+		}
+		objs = append(objs, u)
+	}
+	return objs, nil
+}
+
+func validateManifests(objs []*unstructured.Unstructured) error {
+	if len(objs) == 0 {
+		return badRequestf("no manifests given")
+	}
+	for i, u := range objs {
+		if u.GetKind() == "" || u.GetName() == "" {
+			return badRequestf("manifest %d: kind and metadata.name are required", i)
+		}
+	}
+	return nil
+}
+
+// apply manifests in dependency order, then prune the app's resources that are no longer part of them
+func (k *KubeClient) ApplyManifests(ctx context.Context, req *ApplyManifestsRequest, objs []*unstructured.Unstructured) (result ManifestsResult, err error) {
+	ctx, span := StartSpan(ctx, "ApplyManifests")
+	defer func() { EndSpan(span, err) }()
+
+	result = ManifestsResult{DryRun: req.DryRun}
+	if err := validateManifests(objs); err != nil {
+		return result, err
+	}
+	if req.Prune && req.App == "" {
+		return result, badRequestf("app is required to prune")
+	}
+	if req.App != "" {
+		// the app in every namespace the manifests go to
+		namespaces := []string{req.Namespace}
+		if req.Namespace == "" {
+			namespaces = namespaces[:0]
+			for _, u := range objs {
+				namespaces = append(namespaces, u.GetNamespace())
+			}
+		}
+		unlock, err := k.appLocks.lockAll(ctx, namespaces, req.App)
+		if err != nil {
+			return result, err
+		}
+		defer unlock()
+	}
+
+	sortBundle(objs)
+	for _, u := range objs {
+		if req.App != "" {
+			labels := u.GetLabels()
+			if labels == nil {
+				labels = map[string]string{}
+			}
+			for key, value := range managedLabels(req.App) {
+				labels[key] = value.(string)
+			}
+			u.SetLabels(labels)
+		}
+
+		metadata, err := k.Apply(ctx, u, ApplyOptions{NamespaceOverride: req.Namespace, DryRun: req.DryRun})
+		if err != nil {
+			return result, fmt.Errorf("%s %s: %w", u.GetKind(), u.GetName(), err)
+		}
+		result.Applied = append(result.Applied, metadata)
+
+		// kinds of a new CRD are only known after rediscovery
+		if u.GetKind() == "CustomResourceDefinition" && !req.DryRun {
+			k.mapper.Reset()
+		}
+	}
+
+	if req.Prune {
+		result.Pruned, err = k.Prune(ctx, PruneOptions{App: req.App, DryRun: req.DryRun, Cascade: req.Cascade, GracePeriod: req.GracePeriod}, objs)
+	}
+	return result, err
+}
+
+// delete the resources described by the manifests and targets, dependents first
+func (k *KubeClient) DeleteManifests(ctx context.Context, req *DeleteManifestsRequest, objs []*unstructured.Unstructured) (result ManifestsResult, err error) {
+	ctx, span := StartSpan(ctx, "DeleteManifests")
+	defer func() { EndSpan(span, err) }()
+
+	result = ManifestsResult{DryRun: req.DryRun}
+	targets := append([]DeleteTarget{}, req.Resources...)
+	for _, u := range objs {
+		targets = append(targets, DeleteTarget{
+			APIVersion: u.GetAPIVersion(),
+			Kind:       u.GetKind(),
+			Namespace:  u.GetNamespace(),
+			Name:       u.GetName(),
+		})
+	}
+	if len(targets) == 0 {
+		return result, badRequestf("no manifests or resources given")
+	}
+
+	resolved := []resolvedTarget{}
+	for i, t := range targets {
+		r, err := k.resolveTarget(t)
+		if err != nil {
+			return result, badRequestf("resource %d: %v", i, err)
+		}
+		if req.Namespace != "" {
+			r.Namespace = req.Namespace
+		} else if r.Namespace == "" {
+			r.Namespace = "default"
+		}
+		resolved = append(resolved, r)
+	}
+	sort.SliceStable(resolved, func(i, j int) bool {
+		return kindOrder(resolved[i].gvk.Kind) > kindOrder(resolved[j].gvk.Kind)
+	})
+
+	if req.App != "" {
+		namespaces := []string{}
+		for _, r := range resolved {
+			namespaces = append(namespaces, r.Namespace)
+		}
+		unlock, err := k.appLocks.lockAll(ctx, namespaces, req.App)
+		if err != nil {
+			return result, err
+		}
+		defer unlock()
+	}
+
+	do := metav1.DeleteOptions{}
+	if req.DryRun {
+		do.DryRun = []string{metav1.DryRunAll}
+	}
+	for _, t := range resolved {
+		deleted, err := k.deleteTarget(ctx, t, do)
+		result.Deleted = append(result.Deleted, deleted...)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	if req.Wait && !req.DryRun {
+		targets := []deletionTarget{}
+		for _, d := range result.Deleted {
+			targets = append(targets, deletionTarget{schema.FromAPIVersionAndKind(d.ApiVersion, d.Kind), d.Name, d.Namespace})
+		}
+		return result, k.WaitForDeletions(ctx, targets, req.timeout())
+	}
+	return result, nil
+}
+
+// resolve the target to a kind known to the cluster, without guessing the group or version
+func (k *KubeClient) resolveTarget(t DeleteTarget) (resolvedTarget, error) {
+	r := resolvedTarget{DeleteTarget: t}
+	switch {
+	case t.Name == "" && t.LabelSelector == "":
+		return r, fmt.Errorf("name or labelSelector is required")
+	case t.Name != "" && t.LabelSelector != "":
+		return r, fmt.Errorf("name and labelSelector are mutually exclusive")
+	case t.APIVersion != "" && t.Kind != "":
+		gv, err := schema.ParseGroupVersion(t.APIVersion)
+		if err != nil {
+			return r, err
+		}
+		r.gvk = gv.WithKind(t.Kind)
+	case t.Version != "" && t.Resource != "":
+		gvk, err := k.kindFor(schema.GroupVersionResource{Group: t.Group, Version: t.Version, Resource: t.Resource})
+		if err != nil {
+			return r, err
+		}
+		r.gvk = gvk
+	default:
+		return r, fmt.Errorf("apiVersion and kind, or version and resource are required")
+	}
+	if _, err := labels.Parse(t.LabelSelector); err != nil {
+		return r, err
+	}
+
+	mapping, err := k.restMapping(r.gvk)
+	if err != nil {
+		return r, err
+	}
+	r.mapping = mapping
+	return r, nil
+}
+
+// delete the named resource or every resource matching the label selector
+func (k *KubeClient) deleteTarget(ctx context.Context, t resolvedTarget, do metav1.DeleteOptions) ([]Metadata, error) {
+	names := []string{t.Name}
+	if t.LabelSelector != "" {
+		ri, err := k.resourceInterface(t.gvk, t.Namespace)
+		if err != nil {
+			return nil, err
+		}
+		list, err := ri.List(ctx, metav1.ListOptions{LabelSelector: t.LabelSelector})
+		if err != nil {
+			return nil, err
+		}
+		names = names[:0]
+		for _, item := range list.Items {
+			names = append(names, item.GetName())
+		}
+	}
+
+	deleted := []Metadata{}
+	for _, name := range names {
+		if err := k.DeleteResourceByKindAndNameAndNamespace(ctx, t.gvk, name, t.Namespace, do); err != nil {
+			return deleted, fmt.Errorf("%s %s: %w", t.gvk.Kind, name, err)
+		}
+		metadata := Metadata{
+			Name:       name,
+			ApiVersion: t.gvk.GroupVersion().String(),
+			Resource:   t.mapping.Resource.Resource,
+			Kind:       t.gvk.Kind,
+		}
+		if t.mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+			metadata.Namespace = t.Namespace
+		}
+		deleted = append(deleted, metadata)
+	}
+	return deleted, nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestResolveTarget(t *testing.T) {
+	tests := []struct {
+		name         string
+		target       DeleteTarget
+		wantGVK      schema.GroupVersionKind
+		wantResource string
+		wantErr      bool
+	}{
+		{
+			name:         "apiVersion and kind",
+			target:       DeleteTarget{APIVersion: "apps/v1", Kind: "Deployment", Name: "web"},
+			wantGVK:      deploymentGVK,
+			wantResource: "deployments",
+		},
+		{
+			name:         "core apiVersion and kind",
+			target:       DeleteTarget{APIVersion: "v1", Kind: "ConfigMap", LabelSelector: "app=web"},
+			wantGVK:      schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"},
+			wantResource: "configmaps",
+		},
+		{
+			name:         "group, version and resource",
+			target:       DeleteTarget{Group: "dapr.io", Version: "v1alpha1", Resource: "components", Name: "statestore"},
+			wantGVK:      componentGVK,
+			wantResource: "components",
+		},
+		{
+			name:         "core version and resource",
+			target:       DeleteTarget{Version: "v1", Resource: "services", Name: "web"},
+			wantGVK:      serviceGVK,
+			wantResource: "services",
+		},
+		{
+			name:         "apiVersion and kind win over resource",
+			target:       DeleteTarget{APIVersion: "v1", Kind: "Secret", Version: "v1", Resource: "configmaps", Name: "web"},
+			wantGVK:      schema.GroupVersionKind{Version: "v1", Kind: "Secret"},
+			wantResource: "secrets",
+		},
+		{name: "name or label selector required", target: DeleteTarget{APIVersion: "v1", Kind: "Service"}, wantErr: true},
+		{name: "name and label selector", target: DeleteTarget{APIVersion: "v1", Kind: "Service", Name: "web", LabelSelector: "app=web"}, wantErr: true},
+		{name: "kind without apiVersion", target: DeleteTarget{Kind: "Service", Name: "web"}, wantErr: true},
+		{name: "resource without version", target: DeleteTarget{Group: "apps", Resource: "deployments", Name: "web"}, wantErr: true},
+		{name: "invalid apiVersion", target: DeleteTarget{APIVersion: "a/b/c", Kind: "Service", Name: "web"}, wantErr: true},
+		{name: "invalid label selector", target: DeleteTarget{APIVersion: "v1", Kind: "Service", LabelSelector: "app in (web"}, wantErr: true},
+		{name: "unknown kind", target: DeleteTarget{APIVersion: "batch/v1", Kind: "Job", Name: "migrate"}, wantErr: true},
+		{name: "unknown resource", target: DeleteTarget{Group: "batch", Version: "v1", Resource: "jobs", Name: "migrate"}, wantErr: true},
+	}
+	k, _ := fakeKubeClient()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := k.resolveTarget(tt.target)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("resolveTarget() error = nil, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveTarget() error = %v", err)
+			}
+			if got.gvk != tt.wantGVK {
+				t.Errorf("resolveTarget() gvk = %v, want %v", got.gvk, tt.wantGVK)
+			}
+			if got.mapping.Resource.Resource != tt.wantResource {
+				t.Errorf("resolveTarget() resource = %s, want %s", got.mapping.Resource.Resource, tt.wantResource)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const metricsNamespace = "dapr_automation"
+
+var (
+	httpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_requests_total",
+		Help:      "Number of API requests by route, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of API requests by route, method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "code"})
+
+	kubeOperationsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "kube_operations_total",
+		Help:      "Number of Kubernetes apply, patch and delete operations by GVK and result.",
+	}, []string{"operation", "group", "version", "kind", "result"})
+
+	kubeOperationsInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "kube_operations_in_flight",
+		Help:      "Number of Kubernetes operations currently in progress.",
+	}, []string{"operation"})
+
+	patchConflictRetriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "patch_conflict_retries_total",
+		Help:      "Number of patch retries caused by resource version conflicts.",
+	}, []string{"group", "version", "kind"})
+
+	dcsLookupDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "dcs_lookup_duration_seconds",
+		Help:      "Latency of DCS instance lookups through the Huaweicloud SDK.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	dcsLookupErrorsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "dcs_lookup_errors_total",
+		Help:      "Number of failed DCS instance lookups.",
+	})
+)
+
+// record the result of a Kubernetes operation on the given GVK
+func observeKubeOperation(operation string, gvk schema.GroupVersionKind, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	kubeOperationsTotal.WithLabelValues(operation, gvk.Group, gvk.Version, gvk.Kind, result).Inc()
+}
+
+// mark a Kubernetes operation as in flight, call the returned func when it is done
+func trackInFlight(operation string) func() {
+	gauge := kubeOperationsInFlight.WithLabelValues(operation)
+	gauge.Inc()
+	return gauge.Dec
+}
+
+// statusRecorder keeps the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// pass flushes through so streaming handlers keep working behind the middlewares
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// record request count and latency per route template and status code
+func metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		route := routeTemplate(r)
+		code := strconv.Itoa(rec.status)
+		httpRequestsTotal.WithLabelValues(route, r.Method, code).Inc()
+		httpRequestDuration.WithLabelValues(route, r.Method, code).Observe(time.Since(start).Seconds())
+	})
+}
+
+// path template of the matched route, falls back to the raw path
+func routeTemplate(r *http.Request) string {
+	if current := mux.CurrentRoute(r); current != nil {
+		if tpl, err := current.GetPathTemplate(); err == nil {
+			return tpl
+		}
+	}
+	return r.URL.Path
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// pod template annotation bumped by a rolling restart, same as kubectl rollout restart
+	RestartedAtAnnotation = "kubectl.kubernetes.io/restartedAt"
+	// revision annotation the Deployment controller puts on ReplicaSets
+	RevisionAnnotation = "deployment.kubernetes.io/revision"
+	// change cause annotation copied from the Deployment to its ReplicaSets
+	ChangeCauseAnnotation = "kubernetes.io/change-cause"
+	// label the Deployment controller adds to ReplicaSet pod templates
+	podTemplateHashLabel = "pod-template-hash"
+)
+
+var replicaSetGVK = schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "ReplicaSet"}
+
+type AppScaleRequest struct {
+	Replicas int64 `json:"replicas"`
+}
+
+// AppRollbackRequest is the audited rollback, with the revision it resolved to
+type AppRollbackRequest struct {
+	RequestedRevision int64  `json:"requestedRevision,omitempty"` // the previous revision when 0
+	Revision          int64  `json:"revision"`
+	ReplicaSet        string `json:"replicaSet"`
+}
+
+// AppRevision is one entry of a Deployment's rollout history
+type AppRevision struct {
+	Revision    int64     `json:"revision"`
+	ReplicaSet  string    `json:"replicaSet"`
+	Images      []string  `json:"images"`
+	Replicas    int64     `json:"replicas"`
+	ChangeCause string    `json:"changeCause,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+	Current     bool      `json:"current"`
+}
+
+// patch a resource through the dynamic client, recording metrics, traces and audit
+func (k *KubeClient) patchResource(ctx context.Context, gvk schema.GroupVersionKind, namespace, name string, pt types.PatchType, patch []byte, subresources ...string) (_ *unstructured.Unstructured, err error) {
+	defer trackInFlight("patch")()
+	ctx, span := StartSpan(ctx, "KubeClient.patchResource",
+		attribute.String("k8s.kind", gvk.Kind),
+		attribute.String("k8s.name", name),
+		attribute.String("k8s.namespace", namespace))
+	defer func() {
+		EndSpan(span, err)
+		observeKubeOperation("patch", gvk, err)
+	}()
+
+	mapping, err := k.restMapping(gvk)
+	if err != nil {
+		return nil, err
+	}
+	ri, err := k.resourceInterface(gvk, namespace)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := ri.Patch(ctx, name, pt, patch, metav1.PatchOptions{}, subresources...)
+	if err != nil {
+		return nil, err
+	}
+	RecordAuditResource(ctx, Metadata{
+		Name:       name,
+		Namespace:  namespace,
+		ApiVersion: gvk.GroupVersion().String(),
+		Resource:   mapping.Resource.Resource,
+		Kind:       gvk.Kind,
+
+		ResourceVersion: obj.GetResourceVersion(),
+	}, "patch", patch)
+	LoggerFrom(ctx).Info("patched", "kind", gvk.Kind, "name", name, "namespace", namespace)
+	return obj, nil
+}
+
+// trigger a rolling restart of the app's pods
+func (k *KubeClient) RestartApp(ctx context.Context, namespace, name string) (Metadata, error) {
+	unlock, err := k.appLocks.lock(ctx, namespace, name)
+	if err != nil {
+		return Metadata{}, err
+	}
+	defer unlock()
+
+	if _, err := k.getManagedDeployment(ctx, namespace, name); err != nil {
+		return Metadata{}, err
+	}
+	patch, _ := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"annotations": map[string]interface{}{
+						RestartedAtAnnotation: time.Now().Format(time.RFC3339),
+					},
+				},
+			},
+		},
+	})
+	obj, err := k.patchResource(ctx, deploymentGVK, namespace, name, types.StrategicMergePatchType, patch)
+	if err != nil {
+		return Metadata{}, err
+	}
+	return metadataFor(obj, "deployments"), nil
+}
+
+// set the replica count through the scale subresource
+func (k *KubeClient) ScaleApp(ctx context.Context, namespace, name string, replicas int64) (Metadata, error) {
+	if replicas < 0 {
+		return Metadata{}, errors.NewBadRequest("replicas must not be negative")
+	}
+	unlock, err := k.appLocks.lock(ctx, namespace, name)
+	if err != nil {
+		return Metadata{}, err
+	}
+	defer unlock()
+	if _, err := k.getManagedDeployment(ctx, namespace, name); err != nil {
+		return Metadata{}, err
+	}
+	patch, _ := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{"replicas": replicas},
+	})
+	scale, err := k.patchResource(ctx, deploymentGVK, namespace, name, types.MergePatchType, patch, "scale")
+	if err != nil {
+		return Metadata{}, err
+	}
+	// the Scale shares the resourceVersion of its Deployment
+	return Metadata{
+		Name:       name,
+		Namespace:  namespace,
+		ApiVersion: deploymentGVK.GroupVersion().String(),
+		Resource:   "deployments",
+		Kind:       deploymentGVK.Kind,
+
+		ResourceVersion: scale.GetResourceVersion(),
+	}, nil
+}
+
+// list the revisions of the app, oldest first
+func (k *KubeClient) AppHistory(ctx context.Context, namespace, name string) ([]AppRevision, error) {
+	deployment, err := k.getManagedDeployment(ctx, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	replicaSets, err := k.ownedReplicaSets(ctx, deployment)
+	if err != nil {
+		return nil, err
+	}
+	current := deployment.GetAnnotations()[RevisionAnnotation]
+
+	history := []AppRevision{}
+	for _, rs := range replicaSets {
+		revision, _ := strconv.ParseInt(rs.GetAnnotations()[RevisionAnnotation], 10, 64)
+		entry := AppRevision{
+			Revision:    revision,
+			ReplicaSet:  rs.GetName(),
+			Images:      []string{},
+			ChangeCause: rs.GetAnnotations()[ChangeCauseAnnotation],
+			CreatedAt:   rs.GetCreationTimestamp().Time,
+			Current:     rs.GetAnnotations()[RevisionAnnotation] == current,
+		}
+		entry.Replicas, _, _ = unstructured.NestedInt64(rs.Object, "status", "replicas")
+		containers, _, _ := unstructured.NestedSlice(rs.Object, "spec", "template", "spec", "containers")
+		for _, c := range containers {
+			if image, ok := c.(map[string]interface{})["image"].(string); ok {
+				entry.Images = append(entry.Images, image)
+			}
+		}
+		history = append(history, entry)
+	}
+	sort.Slice(history, func(i, j int) bool { return history[i].Revision < history[j].Revision })
+	return history, nil
+}
+
+// roll the app back to the pod template of a previous revision, 0 means the one before the current
+func (k *KubeClient) RollbackApp(ctx context.Context, namespace, name string, revision int64) (Metadata, error) {
+	unlock, err := k.appLocks.lock(ctx, namespace, name)
+	if err != nil {
+		return Metadata{}, err
+	}
+	defer unlock()
+
+	deployment, err := k.getManagedDeployment(ctx, namespace, name)
+	if err != nil {
+		return Metadata{}, err
+	}
+	replicaSets, err := k.ownedReplicaSets(ctx, deployment)
+	if err != nil {
+		return Metadata{}, err
+	}
+	current, _ := strconv.ParseInt(deployment.GetAnnotations()[RevisionAnnotation], 10, 64)
+
+	target, targetRevision, err := rollbackTarget(replicaSets, current, revision)
+	if err != nil {
+		return Metadata{}, err
+	}
+	RecordAuditRequest(ctx, AppRollbackRequest{RequestedRevision: revision, Revision: targetRevision, ReplicaSet: target.GetName()})
+
+	template, _, err := unstructured.NestedMap(target.Object, "spec", "template")
+	if err != nil {
+		return Metadata{}, err
+	}
+	unstructured.RemoveNestedField(template, "metadata", "labels", podTemplateHashLabel)
+	// the test fails the patch when the Deployment changed since its ReplicaSets were listed
+	patch, _ := json.Marshal([]map[string]interface{}{
+		{"op": "test", "path": "/metadata/resourceVersion", "value": deployment.GetResourceVersion()},
+		{"op": "replace", "path": "/spec/template", "value": template},
+	})
+	obj, err := k.patchResource(ctx, deploymentGVK, namespace, name, types.JSONPatchType, patch)
+	if err != nil {
+		return Metadata{}, err
+	}
+	return metadataFor(obj, "deployments"), nil
+}
+
+// the ReplicaSet of the requested revision, or of the newest one older than the current when revision is 0
+func rollbackTarget(replicaSets []unstructured.Unstructured, current, revision int64) (*unstructured.Unstructured, int64, error) {
+	var target *unstructured.Unstructured
+	var targetRevision int64
+	for i := range replicaSets {
+		rev, err := strconv.ParseInt(replicaSets[i].GetAnnotations()[RevisionAnnotation], 10, 64)
+		if err != nil {
+			continue
+		}
+		if (revision > 0 && rev == revision) || (revision == 0 && rev < current && rev > targetRevision) {
+			target, targetRevision = &replicaSets[i], rev
+		}
+	}
+	if target == nil {
+		if revision == 0 {
+			return nil, 0, errors.NewBadRequest("no previous revision to roll back to")
+		}
+		err := errors.NewNotFound(schema.GroupResource{Group: replicaSetGVK.Group, Resource: "replicasets"}, "")
+		err.ErrStatus.Message = fmt.Sprintf("revision %d not found", revision)
+		return nil, 0, err
+	}
+	if targetRevision == current {
+		return nil, 0, errors.NewBadRequest(fmt.Sprintf("revision %d is already the current revision", targetRevision))
+	}
+	return target, targetRevision, nil
+}
+
+// ReplicaSets controlled by the Deployment
+func (k *KubeClient) ownedReplicaSets(ctx context.Context, deployment *unstructured.Unstructured) ([]unstructured.Unstructured, error) {
+	matchLabels, _, err := unstructured.NestedStringMap(deployment.Object, "spec", "selector", "matchLabels")
+	if err != nil {
+		return nil, err
+	}
+	ri, err := k.resourceInterface(replicaSetGVK, deployment.GetNamespace())
+	if err != nil {
+		return nil, err
+	}
+	list, err := ri.List(ctx, metav1.ListOptions{LabelSelector: labels.SelectorFromSet(matchLabels).String()})
+	if err != nil {
+		return nil, err
+	}
+
+	owned := []unstructured.Unstructured{}
+	for _, rs := range list.Items {
+		if ref := metav1.GetControllerOf(&rs); ref != nil && ref.UID == deployment.GetUID() {
+			owned = append(owned, rs)
+		}
+	}
+	return owned, nil
+}
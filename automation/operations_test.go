@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestRollbackTarget(t *testing.T) {
+	replicaSet := func(name string, revision int) unstructured.Unstructured {
+		u := unstructured.Unstructured{}
+		u.SetName(name)
+		if revision > 0 {
+			u.SetAnnotations(map[string]string{RevisionAnnotation: strconv.Itoa(revision)})
+		}
+		return u
+	}
+	replicaSets := []unstructured.Unstructured{
+		replicaSet("web-1", 1),
+		replicaSet("web-3", 3),
+		replicaSet("web-2", 2),
+		replicaSet("web-unknown", 0),
+		replicaSet("web-4", 4),
+	}
+
+	tests := []struct {
+		name         string
+		replicaSets  []unstructured.Unstructured
+		current      int64
+		revision     int64
+		want         string
+		wantRevision int64
+		wantErr      func(error) bool
+	}{
+		{name: "previous by default", replicaSets: replicaSets, current: 4, want: "web-3", wantRevision: 3},
+		{name: "previous of an older current", replicaSets: replicaSets, current: 3, want: "web-2", wantRevision: 2},
+		{name: "explicit revision", replicaSets: replicaSets, current: 4, revision: 1, want: "web-1", wantRevision: 1},
+		{name: "unknown revision", replicaSets: replicaSets, current: 4, revision: 7, wantErr: errors.IsNotFound},
+		{name: "current revision", replicaSets: replicaSets, current: 4, revision: 4, wantErr: errors.IsBadRequest},
+		{name: "no previous revision", replicaSets: replicaSets[:1], current: 1, wantErr: errors.IsBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, gotRevision, err := rollbackTarget(tt.replicaSets, tt.current, tt.revision)
+			if tt.wantErr != nil {
+				if !tt.wantErr(err) {
+					t.Fatalf("rollbackTarget() error = %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("rollbackTarget() error = %v", err)
+			}
+			if got.GetName() != tt.want || gotRevision != tt.wantRevision {
+				t.Errorf("rollbackTarget() = %s revision %d, want %s revision %d", got.GetName(), gotRevision, tt.want, tt.wantRevision)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	utils "github.com/huaweicloud/dapr-automation/utils"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// kinds searched for leftovers besides the applied ones, so a kind dropped from the manifests is pruned too
+var pruneKinds = []schema.GroupVersionKind{
+	{Group: "", Version: "v1", Kind: "ConfigMap"},
+	{Group: "", Version: "v1", Kind: "Secret"},
+	{Group: "", Version: "v1", Kind: "ServiceAccount"},
+	{Group: "", Version: "v1", Kind: "PersistentVolumeClaim"},
+	serviceGVK,
+	deploymentGVK,
+	{Group: "apps", Version: "v1", Kind: "StatefulSet"},
+	{Group: "apps", Version: "v1", Kind: "DaemonSet"},
+	{Group: "batch", Version: "v1", Kind: "Job"},
+	{Group: "networking.k8s.io", Version: "v1", Kind: "Ingress"},
+	componentGVK,
+	{Group: "dapr.io", Version: "v1alpha1", Kind: "Configuration"},
+	{Group: "dapr.io", Version: "v1alpha1", Kind: "Subscription"},
+}
+
+// PruneOptions selects and deletes the leftovers of an app
+type PruneOptions struct {
+	App         string // app whose ownership label marks the candidates
+	DryRun      bool   // only report what would be pruned
+	Cascade     *bool  // delete dependents too, utils.DefaultCascade when nil
+	GracePeriod *int   // seconds, utils.DefaultGracePeriod when nil
+}
+
+// same options the Patcher uses when it has to delete and recreate a resource
+func (o PruneOptions) deleteOptions() metav1.DeleteOptions {
+	cascade, gracePeriod := utils.DefaultCascade, utils.DefaultGracePeriod
+	if o.Cascade != nil {
+		cascade = *o.Cascade
+	}
+	if o.GracePeriod != nil {
+		gracePeriod = *o.GracePeriod
+	}
+	do := utils.AsDeleteOptions(cascade, gracePeriod)
+	if o.DryRun {
+		do.DryRun = []string{metav1.DryRunAll}
+	}
+	return do
+}
+
+// delete the resources carrying the app's ownership label that are not among the applied objects,
+// looking in the namespaces the objects were applied to
+func (k *KubeClient) Prune(ctx context.Context, opts PruneOptions, applied []*unstructured.Unstructured) (_ []Metadata, err error) {
+	ctx, span := StartSpan(ctx, "Prune")
+	defer func() { EndSpan(span, err) }()
+
+	if opts.App == "" {
+		return nil, badRequestf("app is required to prune")
+	}
+
+	keep := map[string]bool{}
+	gvks := map[schema.GroupKind]string{}
+	namespaces := map[string]bool{}
+	for _, u := range applied {
+		gvk := u.GroupVersionKind()
+		mapping, err := k.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if err != nil {
+			return nil, err
+		}
+		// cluster-scoped objects live in no namespace, whatever their manifest says
+		namespace := ""
+		if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+			namespace = u.GetNamespace()
+			if namespace == "" {
+				namespace = "default"
+			}
+			namespaces[namespace] = true
+		}
+		keep[pruneKey(gvk.GroupKind(), namespace, u.GetName())] = true
+		gvks[gvk.GroupKind()] = gvk.Version
+	}
+	for _, gvk := range pruneKinds {
+		if _, ok := gvks[gvk.GroupKind()]; !ok {
+			gvks[gvk.GroupKind()] = gvk.Version
+		}
+	}
+
+	candidates, resources, err := k.pruneCandidates(ctx, opts.App, gvks, namespaces, keep)
+	if err != nil {
+		return nil, err
+	}
+
+	// dependents go before what they depend on
+	sortBundle(candidates)
+	pruned := []Metadata{}
+	do := opts.deleteOptions()
+	for i := len(candidates) - 1; i >= 0; i-- {
+		u := candidates[i]
+		if err := k.DeleteResourceByKindAndNameAndNamespace(ctx, u.GroupVersionKind(), u.GetName(), u.GetNamespace(), do); err != nil {
+			return pruned, fmt.Errorf("prune %s %s: %w", u.GetKind(), u.GetName(), err)
+		}
+		pruned = append(pruned, metadataFor(u, resources[u.GroupVersionKind().GroupKind()]))
+	}
+	LoggerFrom(ctx).Info("pruned", "app", opts.App, "dryRun", opts.DryRun, "resources", len(pruned))
+	return pruned, nil
+}
+
+// list the app's resources of the given kinds that are not kept, kinds unknown to the cluster are skipped.
+// The resource name of each listed kind is returned along with the candidates.
+func (k *KubeClient) pruneCandidates(ctx context.Context, app string, gvks map[schema.GroupKind]string, namespaces, keep map[string]bool) ([]*unstructured.Unstructured, map[schema.GroupKind]string, error) {
+	selector := managedSelector() + "," + AppLabel + "=" + app
+	candidates := []*unstructured.Unstructured{}
+	resources := map[schema.GroupKind]string{}
+	for gk, version := range gvks {
+		gvk := gk.WithVersion(version)
+		mapping, err := k.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if meta.IsNoMatchError(err) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		resources[gk] = mapping.Resource.Resource
+
+		scopes := []string{""}
+		if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+			scopes = scopes[:0]
+			for namespace := range namespaces {
+				scopes = append(scopes, namespace)
+			}
+		}
+		for _, namespace := range scopes {
+			ri, err := k.resourceInterface(gvk, namespace)
+			if err != nil {
+				return nil, nil, err
+			}
+			list, err := ri.List(ctx, metav1.ListOptions{LabelSelector: selector})
+			if err != nil {
+				return nil, nil, err
+			}
+			for i := range list.Items {
+				item := &list.Items[i]
+				if !keep[pruneKey(gvk.GroupKind(), item.GetNamespace(), item.GetName())] {
+					candidates = append(candidates, item)
+				}
+			}
+		}
+	}
+	return candidates, resources, nil
+}
+
+func pruneKey(gk schema.GroupKind, namespace, name string) string {
+	return gk.String() + "/" + namespace + "/" + name
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery/cached/memory"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/restmapper"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+// resources served by the fake cluster, Jobs, Ingresses and the other Dapr kinds are unknown to it
+var fakeResources = []*metav1.APIResourceList{
+	{GroupVersion: "v1", APIResources: []metav1.APIResource{
+		{Name: "configmaps", Kind: "ConfigMap", Namespaced: true},
+		{Name: "secrets", Kind: "Secret", Namespaced: true},
+		{Name: "serviceaccounts", Kind: "ServiceAccount", Namespaced: true},
+		{Name: "persistentvolumeclaims", Kind: "PersistentVolumeClaim", Namespaced: true},
+		{Name: "services", Kind: "Service", Namespaced: true},
+		{Name: "namespaces", Kind: "Namespace"},
+	}},
+	{GroupVersion: "apps/v1", APIResources: []metav1.APIResource{
+		{Name: "deployments", Kind: "Deployment", Namespaced: true},
+		{Name: "statefulsets", Kind: "StatefulSet", Namespaced: true},
+		{Name: "daemonsets", Kind: "DaemonSet", Namespaced: true},
+	}},
+	{GroupVersion: "rbac.authorization.k8s.io/v1", APIResources: []metav1.APIResource{
+		{Name: "clusterroles", Kind: "ClusterRole"},
+	}},
+	{GroupVersion: "dapr.io/v1alpha1", APIResources: []metav1.APIResource{
+		{Name: "components", Kind: "Component", Namespaced: true},
+	}},
+}
+
+// KubeClient backed by a fake dynamic client holding objects, with a mapper discovering fakeResources
+func fakeKubeClient(objects ...runtime.Object) (*KubeClient, *dynamicfake.FakeDynamicClient) {
+	listKinds := map[schema.GroupVersionResource]string{}
+	for _, list := range fakeResources {
+		gv, _ := schema.ParseGroupVersion(list.GroupVersion)
+		for _, r := range list.APIResources {
+			listKinds[gv.WithResource(r.Name)] = r.Kind + "List"
+		}
+	}
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds, objects...)
+	discovery := &fakediscovery.FakeDiscovery{Fake: &clienttesting.Fake{Resources: fakeResources}}
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(discovery))
+	return &KubeClient{c: client, mapper: mapper}, client
+}
+
+func fakeObject(apiVersion, kind, namespace, name, app string) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{}
+	u.SetAPIVersion(apiVersion)
+	u.SetKind(kind)
+	u.SetNamespace(namespace)
+	u.SetName(name)
+	if app != "" {
+		labels := map[string]string{}
+		for key, value := range managedLabels(app) {
+			labels[key] = value.(string)
+		}
+		u.SetLabels(labels)
+	}
+	return u
+}
+
+func TestPrune(t *testing.T) {
+	live := []runtime.Object{
+		fakeObject("apps/v1", "Deployment", "default", "web", "shop"),
+		fakeObject("v1", "Service", "default", "web", "shop"),
+		fakeObject("v1", "ConfigMap", "default", "old-config", "shop"),
+		fakeObject("v1", "Secret", "prod", "old-secret", "shop"),
+		fakeObject("v1", "ConfigMap", "other", "not-applied-to", "shop"),
+		fakeObject("v1", "ConfigMap", "default", "other-app", "cart"),
+		fakeObject("v1", "ConfigMap", "default", "unmanaged", ""),
+		fakeObject("dapr.io/v1alpha1", "Component", "default", "old-store", "shop"),
+		fakeObject("rbac.authorization.k8s.io/v1", "ClusterRole", "", "web-reader", "shop"),
+		fakeObject("rbac.authorization.k8s.io/v1", "ClusterRole", "", "old-reader", "shop"),
+	}
+	applied := func() []*unstructured.Unstructured {
+		return []*unstructured.Unstructured{
+			fakeObject("apps/v1", "Deployment", "default", "web", "shop"),
+			fakeObject("v1", "Service", "default", "web", "shop"),
+			fakeObject("v1", "Secret", "prod", "kept-secret", "shop"),
+			// a namespace in the manifest of a cluster-scoped kind is ignored
+			fakeObject("rbac.authorization.k8s.io/v1", "ClusterRole", "default", "web-reader", "shop"),
+		}
+	}
+	wantPruned := []string{
+		"ClusterRole//old-reader",
+		"Component/default/old-store",
+		"ConfigMap/default/old-config",
+		"Secret/prod/old-secret",
+	}
+
+	tests := []struct {
+		name       string
+		opts       PruneOptions
+		applied    []*unstructured.Unstructured
+		wantPruned []string
+		wantErr    bool
+	}{
+		{name: "prune", opts: PruneOptions{App: "shop"}, applied: applied(), wantPruned: wantPruned},
+		{name: "dry run", opts: PruneOptions{App: "shop", DryRun: true}, applied: applied(), wantPruned: wantPruned},
+		{name: "app required", opts: PruneOptions{}, applied: applied(), wantErr: true},
+		{
+			name:    "unknown applied kind",
+			opts:    PruneOptions{App: "shop"},
+			applied: []*unstructured.Unstructured{fakeObject("batch/v1", "Job", "default", "migrate", "shop")},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, client := fakeKubeClient(live...)
+			pruned, err := k.Prune(context.Background(), tt.opts, tt.applied)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Prune() error = nil, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Prune() error = %v", err)
+			}
+
+			got := []string{}
+			for _, m := range pruned {
+				got = append(got, m.Kind+"/"+m.Namespace+"/"+m.Name)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.wantPruned) {
+				t.Errorf("Prune() = %v, want %v", got, tt.wantPruned)
+			}
+
+			deleted := []string{}
+			for _, action := range client.Actions() {
+				if action, ok := action.(clienttesting.DeleteAction); ok {
+					deleted = append(deleted, action.GetResource().Resource+"/"+action.GetNamespace()+"/"+action.GetName())
+				}
+			}
+			if len(deleted) != len(tt.wantPruned) {
+				t.Errorf("Prune() deleted %v, want %d deletes", deleted, len(tt.wantPruned))
+			}
+		})
+	}
+}
+
+func TestPruneCandidates(t *testing.T) {
+	live := []runtime.Object{
+		fakeObject("v1", "ConfigMap", "default", "kept", "shop"),
+		fakeObject("v1", "ConfigMap", "default", "old-config", "shop"),
+		fakeObject("v1", "ConfigMap", "other", "not-applied-to", "shop"),
+		fakeObject("v1", "ConfigMap", "default", "other-app", "cart"),
+		fakeObject("v1", "ConfigMap", "default", "unmanaged", ""),
+		fakeObject("rbac.authorization.k8s.io/v1", "ClusterRole", "", "kept", "shop"),
+		fakeObject("rbac.authorization.k8s.io/v1", "ClusterRole", "", "old-reader", "shop"),
+	}
+	configMaps := schema.GroupKind{Kind: "ConfigMap"}
+	clusterRoles := schema.GroupKind{Group: "rbac.authorization.k8s.io", Kind: "ClusterRole"}
+	keep := map[string]bool{
+		pruneKey(configMaps, "default", "kept"): true,
+		pruneKey(clusterRoles, "", "kept"):      true,
+	}
+
+	tests := []struct {
+		name          string
+		gvks          map[schema.GroupKind]string
+		want          []string
+		wantResources map[schema.GroupKind]string
+	}{
+		{
+			name:          "namespaced kind in the applied namespaces",
+			gvks:          map[schema.GroupKind]string{configMaps: "v1"},
+			want:          []string{"ConfigMap/default/old-config"},
+			wantResources: map[schema.GroupKind]string{configMaps: "configmaps"},
+		},
+		{
+			name:          "cluster-scoped kind",
+			gvks:          map[schema.GroupKind]string{clusterRoles: "v1"},
+			want:          []string{"ClusterRole//old-reader"},
+			wantResources: map[schema.GroupKind]string{clusterRoles: "clusterroles"},
+		},
+		{
+			name: "unknown kinds are skipped",
+			gvks: map[schema.GroupKind]string{
+				configMaps:                                    "v1",
+				{Group: "batch", Kind: "Job"}:                 "v1",
+				{Group: "dapr.io", Kind: "Subscription"}:      "v1alpha1",
+				{Group: "networking.k8s.io", Kind: "Ingress"}: "v1",
+			},
+			want:          []string{"ConfigMap/default/old-config"},
+			wantResources: map[schema.GroupKind]string{configMaps: "configmaps"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, _ := fakeKubeClient(live...)
+			candidates, resources, err := k.pruneCandidates(context.Background(), "shop", tt.gvks, map[string]bool{"default": true}, keep)
+			if err != nil {
+				t.Fatalf("pruneCandidates() error = %v", err)
+			}
+			got := []string{}
+			for _, u := range candidates {
+				got = append(got, u.GetKind()+"/"+u.GetNamespace()+"/"+u.GetName())
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pruneCandidates() = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(resources, tt.wantResources) {
+				t.Errorf("pruneCandidates() resources = %v, want %v", resources, tt.wantResources)
+			}
+		})
+	}
+}
+
+func TestPruneOptionsDeleteOptions(t *testing.T) {
+	if do := (PruneOptions{DryRun: true}).deleteOptions(); !reflect.DeepEqual(do.DryRun, []string{metav1.DryRunAll}) {
+		t.Errorf("deleteOptions() DryRun = %v, want %v", do.DryRun, []string{metav1.DryRunAll})
+	}
+	if do := (PruneOptions{}).deleteOptions(); do.DryRun != nil {
+		t.Errorf("deleteOptions() DryRun = %v, want none", do.DryRun)
+	}
+}
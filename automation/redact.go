@@ -0,0 +1,120 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// annotation kubectl style apply keeps the whole manifest in, secret values included
+const lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// Dapr Component metadata item names containing one of these are treated as secrets
+var secretFieldHints = []string{"password", "secret", "token", "credential", "key", "connectionstring"}
+
+func isSecretField(name string) bool {
+	lower := strings.ToLower(name)
+	for _, hint := range secretFieldHints {
+		if strings.Contains(lower, hint) {
+			return true
+		}
+	}
+	return false
+}
+
+// mask the secret values of an object or patch in place, see redactObjects
+func redactObject(kind string, content map[string]interface{}) {
+	redactObjects(kind, nil, content)
+}
+
+// mask the secret values of two versions of an object in place: Secret data, Dapr Component
+// metadata values that look like secrets, and the last applied configuration, which repeats them.
+// from is nil when there is nothing to compare with, otherwise changed values are marked
+// so a diff still tells what changed.
+func redactObjects(kind string, from, to map[string]interface{}) {
+	for _, content := range []map[string]interface{}{from, to} {
+		unstructured.RemoveNestedField(content, "metadata", "annotations", lastAppliedAnnotation)
+	}
+	compare := from != nil
+	switch kind {
+	case "Secret":
+		for _, field := range []string{"data", "stringData"} {
+			before, _, _ := unstructured.NestedMap(from, field)
+			after, _, _ := unstructured.NestedMap(to, field)
+			maskValues(before, after, compare)
+			if before != nil {
+				unstructured.SetNestedMap(from, before, field)
+			}
+			if after != nil {
+				unstructured.SetNestedMap(to, after, field)
+			}
+		}
+	case "Component":
+		before, after := componentSecretValues(from), componentSecretValues(to)
+		maskValues(before, after, compare)
+		setComponentSecretValues(from, before)
+		setComponentSecretValues(to, after)
+	}
+}
+
+// replace non-empty values with redactedValue, when comparing the ones that differ
+// between before and after are marked as such
+func maskValues(before, after map[string]interface{}, compare bool) {
+	for key, value := range before {
+		if value == nil || value == "" {
+			continue
+		}
+		if afterValue, ok := after[key]; compare && (!ok || !reflect.DeepEqual(value, afterValue)) {
+			before[key] = redactedValue + " (before)"
+		} else {
+			before[key] = redactedValue
+		}
+	}
+	for key, value := range after {
+		if value == nil || value == "" {
+			continue
+		}
+		if _, ok := before[key]; compare && (!ok || before[key] != redactedValue) {
+			after[key] = redactedValue + " (after)"
+		} else {
+			after[key] = redactedValue
+		}
+	}
+}
+
+// inline values of the Component metadata items that look like secrets, by item name
+func componentSecretValues(content map[string]interface{}) map[string]interface{} {
+	items, found, _ := unstructured.NestedSlice(content, "spec", "metadata")
+	if !found {
+		return nil
+	}
+	values := map[string]interface{}{}
+	for _, item := range items {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _ := m["name"].(string)
+		if value, ok := m["value"]; ok && isSecretField(name) {
+			values[name] = value
+		}
+	}
+	return values
+}
+
+func setComponentSecretValues(content map[string]interface{}, values map[string]interface{}) {
+	if values == nil {
+		return
+	}
+	items, _, _ := unstructured.NestedSlice(content, "spec", "metadata")
+	for _, item := range items {
+		if m, ok := item.(map[string]interface{}); ok {
+			name, _ := m["name"].(string)
+			if value, ok := values[name]; ok {
+				m["value"] = value
+			}
+		}
+	}
+	unstructured.SetNestedSlice(content, items, "spec", "metadata")
+}
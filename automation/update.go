@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/kubectl/pkg/util"
+)
+
+// prefix of the annotations Dapr reads from the pod template
+const daprAnnotationPrefix = "dapr.io/"
+
+type AppUpdateRequest struct {
+	Images          map[string]string                 `json:"images"`          // container name -> image
+	Replicas        *int64                            `json:"replicas"`        // desired replica count
+	Env             map[string]map[string]*string     `json:"env"`             // container name -> env name -> value, null removes the variable
+	Resources       map[string]ContainerResourcesSpec `json:"resources"`       // container name -> resource limits and requests
+	DaprAnnotations map[string]string                 `json:"daprAnnotations"` // dapr.io/* pod annotations, prefix optional, empty value removes the annotation
+	ResourceVersion string                            `json:"resourceVersion"` // resourceVersion the Deployment must still have, any when empty
+}
+
+// copy of the request that is safe to log, env values are masked and removals kept
+func (req AppUpdateRequest) Redacted() AppUpdateRequest {
+	if req.Env == nil {
+		return req
+	}
+	env := make(map[string]map[string]*string, len(req.Env))
+	for container, values := range req.Env {
+		masked := make(map[string]*string, len(values))
+		for name, value := range values {
+			if value != nil {
+				redacted := redact(*value)
+				value = &redacted
+			}
+			masked[name] = value
+		}
+		env[container] = masked
+	}
+	req.Env = env
+	return req
+}
+
+type ContainerResourcesSpec struct {
+	Limits   map[string]string `json:"limits"`
+	Requests map[string]string `json:"requests"`
+}
+
+// apply targeted changes to a managed app's Deployment
+func (k *KubeClient) UpdateApp(ctx context.Context, namespace, name string, req *AppUpdateRequest) (Metadata, error) {
+	unlock, err := k.appLocks.lock(ctx, namespace, name)
+	if err != nil {
+		return Metadata{}, err
+	}
+	defer unlock()
+
+	live, err := k.getManagedDeployment(ctx, namespace, name)
+	if err != nil {
+		return Metadata{}, err
+	}
+
+	// start from the last applied configuration so the three-way merge only sees our changes
+	deployment, err := lastAppliedOrLive(live)
+	if err != nil {
+		return Metadata{}, err
+	}
+
+	if err := applyAppUpdate(deployment, req); err != nil {
+		return Metadata{}, err
+	}
+
+	// fail before computing the patch when the Deployment already moved on, the patch itself is guarded too
+	if req.ResourceVersion != "" && req.ResourceVersion != live.GetResourceVersion() {
+		return Metadata{}, resourceVersionConflict(schema.GroupResource{Group: deploymentGVK.Group, Resource: "deployments"}, name, req.ResourceVersion, live.GetResourceVersion())
+	}
+	return k.Apply(ctx, deployment, ApplyOptions{NamespaceOverride: namespace, ResourceVersion: req.ResourceVersion})
+}
+
+// decode the last-applied-configuration annotation, falls back to a cleaned copy of the live object
+func lastAppliedOrLive(live *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	original, err := util.GetOriginalConfiguration(live)
+	if err != nil {
+		return nil, err
+	}
+	if len(original) > 0 {
+		return JSONToUnstructured(original)
+	}
+
+	u := live.DeepCopy()
+	delete(u.Object, "status")
+	unstructured.RemoveNestedField(u.Object, "metadata", "managedFields")
+	unstructured.RemoveNestedField(u.Object, "metadata", "resourceVersion")
+	unstructured.RemoveNestedField(u.Object, "metadata", "uid")
+	unstructured.RemoveNestedField(u.Object, "metadata", "generation")
+	unstructured.RemoveNestedField(u.Object, "metadata", "creationTimestamp")
+	return u, nil
+}
+
+func applyAppUpdate(deployment *unstructured.Unstructured, req *AppUpdateRequest) error {
+	if req.Replicas != nil {
+		if *req.Replicas < 0 {
+			return errors.NewBadRequest("replicas must not be negative")
+		}
+		if err := unstructured.SetNestedField(deployment.Object, *req.Replicas, "spec", "replicas"); err != nil {
+			return err
+		}
+	}
+
+	containers, _, err := unstructured.NestedSlice(deployment.Object, "spec", "template", "spec", "containers")
+	if err != nil {
+		return err
+	}
+	known := map[string]bool{}
+	for i, c := range containers {
+		container, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		containerName, _ := container["name"].(string)
+		known[containerName] = true
+
+		if image, ok := req.Images[containerName]; ok {
+			container["image"] = image
+		}
+		if env, ok := req.Env[containerName]; ok {
+			container["env"] = mergeEnv(container["env"], env)
+		}
+		if resources, ok := req.Resources[containerName]; ok {
+			container["resources"] = mergeResources(container["resources"], resources)
+		}
+		containers[i] = container
+	}
+
+	// reject changes for containers the Deployment does not have
+	for n := range req.Images {
+		if !known[n] {
+			return errors.NewBadRequest(fmt.Sprintf("container %s not found", n))
+		}
+	}
+	for n := range req.Env {
+		if !known[n] {
+			return errors.NewBadRequest(fmt.Sprintf("container %s not found", n))
+		}
+	}
+	for n, spec := range req.Resources {
+		if !known[n] {
+			return errors.NewBadRequest(fmt.Sprintf("container %s not found", n))
+		}
+		if err := spec.validate(); err != nil {
+			return errors.NewBadRequest(fmt.Sprintf("container %s: %v", n, err))
+		}
+	}
+	if err := unstructured.SetNestedSlice(deployment.Object, containers, "spec", "template", "spec", "containers"); err != nil {
+		return err
+	}
+
+	if len(req.DaprAnnotations) > 0 {
+		annotations, _, err := unstructured.NestedStringMap(deployment.Object, "spec", "template", "metadata", "annotations")
+		if err != nil {
+			return err
+		}
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		for key, value := range req.DaprAnnotations {
+			if !strings.HasPrefix(key, daprAnnotationPrefix) {
+				key = daprAnnotationPrefix + key
+			}
+			if value == "" {
+				delete(annotations, key)
+			} else {
+				annotations[key] = value
+			}
+		}
+		if err := unstructured.SetNestedStringMap(deployment.Object, annotations, "spec", "template", "metadata", "annotations"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// set or remove env variables, keeping the order of the existing ones
+func mergeEnv(current interface{}, changes map[string]*string) []interface{} {
+	existing, _ := current.([]interface{})
+	merged := []interface{}{}
+	seen := map[string]bool{}
+	for _, e := range existing {
+		entry, ok := e.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		envName, _ := entry["name"].(string)
+		value, changed := changes[envName]
+		seen[envName] = true
+		switch {
+		case !changed:
+			merged = append(merged, entry)
+		case value == nil:
+			// removed
+		default:
+			merged = append(merged, map[string]interface{}{"name": envName, "value": *value})
+		}
+	}
+	added := []string{}
+	for envName := range changes {
+		added = append(added, envName)
+	}
+	sort.Strings(added)
+	for _, envName := range added {
+		if value := changes[envName]; !seen[envName] && value != nil {
+			merged = append(merged, map[string]interface{}{"name": envName, "value": *value})
+		}
+	}
+	return merged
+}
+
+func (spec ContainerResourcesSpec) validate() error {
+	for _, values := range []map[string]string{spec.Limits, spec.Requests} {
+		for resourceName, quantity := range values {
+			if _, err := resource.ParseQuantity(quantity); err != nil {
+				return fmt.Errorf("invalid quantity %q for %s: %v", quantity, resourceName, err)
+			}
+		}
+	}
+	return nil
+}
+
+func mergeResources(current interface{}, spec ContainerResourcesSpec) map[string]interface{} {
+	resources, _ := current.(map[string]interface{})
+	if resources == nil {
+		resources = map[string]interface{}{}
+	}
+	for field, values := range map[string]map[string]string{"limits": spec.Limits, "requests": spec.Requests} {
+		if len(values) == 0 {
+			continue
+		}
+		quantities, _ := resources[field].(map[string]interface{})
+		if quantities == nil {
+			quantities = map[string]interface{}{}
+		}
+		for resourceName, quantity := range values {
+			quantities[resourceName] = quantity
+		}
+		resources[field] = quantities
+	}
+	return resources
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func strPtr(s string) *string { return &s }
+
+func envEntry(name, value string) map[string]interface{} {
+	return map[string]interface{}{"name": name, "value": value}
+}
+
+func TestMergeEnv(t *testing.T) {
+	valueFrom := map[string]interface{}{
+		"name":      "FROM_SECRET",
+		"valueFrom": map[string]interface{}{"secretKeyRef": map[string]interface{}{"name": "s", "key": "k"}},
+	}
+	tests := []struct {
+		name    string
+		current interface{}
+		changes map[string]*string
+		want    []interface{}
+	}{
+		{
+			name:    "add to empty",
+			current: nil,
+			changes: map[string]*string{"B": strPtr("2"), "A": strPtr("1")},
+			want:    []interface{}{envEntry("A", "1"), envEntry("B", "2")},
+		},
+		{
+			name:    "override keeps position",
+			current: []interface{}{envEntry("A", "1"), envEntry("B", "2"), envEntry("C", "3")},
+			changes: map[string]*string{"B": strPtr("20")},
+			want:    []interface{}{envEntry("A", "1"), envEntry("B", "20"), envEntry("C", "3")},
+		},
+		{
+			name:    "remove",
+			current: []interface{}{envEntry("A", "1"), envEntry("B", "2")},
+			changes: map[string]*string{"A": nil},
+			want:    []interface{}{envEntry("B", "2")},
+		},
+		{
+			name:    "removing a missing variable is a no-op",
+			current: []interface{}{envEntry("A", "1")},
+			changes: map[string]*string{"MISSING": nil},
+			want:    []interface{}{envEntry("A", "1")},
+		},
+		{
+			name:    "override, remove and add together",
+			current: []interface{}{envEntry("A", "1"), valueFrom, envEntry("C", "3")},
+			changes: map[string]*string{"A": strPtr(""), "C": nil, "D": strPtr("4")},
+			want:    []interface{}{envEntry("A", ""), valueFrom, envEntry("D", "4")},
+		},
+		{
+			name:    "valueFrom is replaced by a value",
+			current: []interface{}{valueFrom},
+			changes: map[string]*string{"FROM_SECRET": strPtr("plain")},
+			want:    []interface{}{envEntry("FROM_SECRET", "plain")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mergeEnv(tt.current, tt.changes); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergeEnv() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeResources(t *testing.T) {
+	tests := []struct {
+		name    string
+		current interface{}
+		spec    ContainerResourcesSpec
+		want    map[string]interface{}
+	}{
+		{
+			name:    "set on empty",
+			current: nil,
+			spec:    ContainerResourcesSpec{Limits: map[string]string{"cpu": "500m"}},
+			want:    map[string]interface{}{"limits": map[string]interface{}{"cpu": "500m"}},
+		},
+		{
+			name: "override and keep the rest",
+			current: map[string]interface{}{
+				"limits":   map[string]interface{}{"cpu": "1", "memory": "1Gi"},
+				"requests": map[string]interface{}{"cpu": "100m"},
+			},
+			spec: ContainerResourcesSpec{Limits: map[string]string{"memory": "2Gi"}},
+			want: map[string]interface{}{
+				"limits":   map[string]interface{}{"cpu": "1", "memory": "2Gi"},
+				"requests": map[string]interface{}{"cpu": "100m"},
+			},
+		},
+		{
+			name:    "limits and requests",
+			current: map[string]interface{}{},
+			spec:    ContainerResourcesSpec{Limits: map[string]string{"cpu": "1"}, Requests: map[string]string{"cpu": "250m"}},
+			want: map[string]interface{}{
+				"limits":   map[string]interface{}{"cpu": "1"},
+				"requests": map[string]interface{}{"cpu": "250m"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mergeResources(tt.current, tt.spec); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergeResources() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAppUpdateRequestRedacted(t *testing.T) {
+	req := AppUpdateRequest{
+		Images: map[string]string{"app": "app:v2"},
+		Env:    map[string]map[string]*string{"app": {"PASSWORD": strPtr("secret"), "EMPTY": strPtr(""), "REMOVED": nil}},
+	}
+	redacted := req.Redacted()
+
+	env := redacted.Env["app"]
+	if got := *env["PASSWORD"]; got != redactedValue {
+		t.Errorf("PASSWORD = %q, want %q", got, redactedValue)
+	}
+	if got := *env["EMPTY"]; got != "" {
+		t.Errorf("EMPTY = %q, want empty", got)
+	}
+	if env["REMOVED"] != nil {
+		t.Errorf("REMOVED = %q, want nil", *env["REMOVED"])
+	}
+	if got := *req.Env["app"]["PASSWORD"]; got != "secret" {
+		t.Errorf("original request changed, PASSWORD = %q", got)
+	}
+	if !reflect.DeepEqual(redacted.Images, req.Images) {
+		t.Errorf("Images = %v, want %v", redacted.Images, req.Images)
+	}
+}
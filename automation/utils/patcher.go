@@ -6,12 +6,17 @@ https://github.com/kubernetes/kubectl/blob/master/pkg/cmd/apply/patcher.go
 package utils
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
 	"time"
 
 	"github.com/jonboulle/clockwork"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -36,6 +41,11 @@ const (
 	backOffPeriod = 1 * time.Second
 	// how many times we can retry before back off
 	triesBeforeBackOff = 1
+
+	// dependents are deleted with their owner
+	DefaultCascade = true
+	// use the grace period of the resource
+	DefaultGracePeriod = -1
 )
 
 func NewPatcher(info *resource.Info, helper *resource.Helper) (*Patcher, error) {
@@ -47,9 +57,9 @@ func NewPatcher(info *resource.Info, helper *resource.Helper) (*Patcher, error)
 		Overwrite:     true,
 		BackOff:       clockwork.NewRealClock(),
 		Force:         false,
-		Cascade:       true,
+		Cascade:       DefaultCascade,
 		Timeout:       time.Duration(0),
-		GracePeriod:   -1,
+		GracePeriod:   DefaultGracePeriod,
 		OpenapiSchema: openapiSchema,
 		Retries:       0,
 	}, nil
@@ -73,32 +83,40 @@ type Patcher struct {
 	// Number of retries to make if the patch fails with conflict
 	Retries int
 
+	// If set, called before every retry caused by a conflict
+	OnConflictRetry func()
+
 	OpenapiSchema openapi.Resources
 }
 
 // Patch tries to patch an OpenAPI resource. On success, returns the merge patch as well
 // the final patched object. On failure, returns an error.
-func (p *Patcher) Patch(current runtime.Object, modified []byte,
+// Every attempt is traced as a child span of the span in ctx.
+func (p *Patcher) Patch(ctx context.Context, current runtime.Object, modified []byte,
 	namespace, name string) ([]byte, runtime.Object, error) {
 	var getErr error
 
-	patchBytes, patchObject, err := p.patchSimple(current, modified, namespace, name)
+	patchBytes, patchObject, err := p.tracedPatchSimple(ctx, 0, current, modified, namespace, name)
 
 	if p.Retries == 0 {
 		p.Retries = maxPatchRetry
 	}
 
-	for i := 1; i <= p.Retries && errors.IsConflict(err); i++ {
+	// a conflict with the expected resourceVersion is final, retrying would only fail again
+	for i := 1; i <= p.Retries && errors.IsConflict(err) && p.ResourceVersion == nil; i++ {
 		if i > triesBeforeBackOff {
 			p.BackOff.Sleep(backOffPeriod)
 		}
+		if p.OnConflictRetry != nil {
+			p.OnConflictRetry()
+		}
 
 		current, getErr = p.Helper.Get(namespace, name)
 		if getErr != nil {
 			return nil, nil, getErr
 		}
 
-		patchBytes, patchObject, err = p.patchSimple(current, modified, namespace, name)
+		patchBytes, patchObject, err = p.tracedPatchSimple(ctx, i, current, modified, namespace, name)
 	}
 
 	if err != nil && (errors.IsConflict(err) || errors.IsInvalid(err)) && p.Force {
@@ -108,17 +126,35 @@ func (p *Patcher) Patch(current runtime.Object, modified []byte,
 	return patchBytes, patchObject, err
 }
 
-func (p *Patcher) patchSimple(obj runtime.Object, modified []byte, namespace, name string) ([]byte, runtime.Object, error) {
+func (p *Patcher) tracedPatchSimple(ctx context.Context, retry int, obj runtime.Object, modified []byte, namespace, name string) ([]byte, runtime.Object, error) {
+	_, span := otel.Tracer("dapr-automation").Start(ctx, "Patcher.patchSimple", trace.WithAttributes(
+		attribute.Int("patch.retry", retry),
+		attribute.String("k8s.kind", p.Mapping.GroupVersionKind.Kind),
+		attribute.String("k8s.name", name),
+		attribute.String("k8s.namespace", namespace)))
+	defer span.End()
+
+	patch, patchedObj, err := p.patchSimple(obj, modified, namespace, name)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return patch, patchedObj, err
+}
+
+// CalculatePatch computes the three-way patch from the last applied configuration of obj,
+// the modified configuration and the current state of obj, and returns it with its type.
+func (p *Patcher) CalculatePatch(obj runtime.Object, modified []byte) (types.PatchType, []byte, error) {
 	// Serialize the current configuration of the object from the server.
 	current, err := runtime.Encode(unstructured.UnstructuredJSONScheme, obj)
 	if err != nil {
-		return nil, nil, err
+		return "", nil, err
 	}
 
 	// Retrieve the original configuration of the object from the annotation.
 	original, err := util.GetOriginalConfiguration(obj)
 	if err != nil {
-		return nil, nil, err
+		return "", nil, err
 	}
 
 	var patchType types.PatchType
@@ -139,12 +175,12 @@ func (p *Patcher) patchSimple(obj runtime.Object, modified []byte, namespace, na
 		patch, err = jsonmergepatch.CreateThreeWayJSONMergePatch(original, modified, current, preconditions...)
 		if err != nil {
 			if mergepatch.IsPreconditionFailed(err) {
-				return nil, nil, fmt.Errorf("%s", "At least one of apiVersion, kind and name was changed")
+				return "", nil, fmt.Errorf("%s", "At least one of apiVersion, kind and name was changed")
 			}
-			return nil, nil, err
+			return "", nil, err
 		}
 	case err != nil:
-		return nil, nil, err
+		return "", nil, err
 	case err == nil:
 		// Compute a three way strategic merge patch to send to server.
 		patchType = types.StrategicMergePatchType
@@ -166,15 +202,24 @@ func (p *Patcher) patchSimple(obj runtime.Object, modified []byte, namespace, na
 		if patch == nil {
 			lookupPatchMeta, err = strategicpatch.NewPatchMetaFromStruct(versionedObject)
 			if err != nil {
-				return nil, nil, err
+				return "", nil, err
 			}
 			patch, err = strategicpatch.CreateThreeWayMergePatch(original, modified, current, lookupPatchMeta, p.Overwrite)
 			if err != nil {
-				return nil, nil, err
+				return "", nil, err
 			}
 		}
 	}
 
+	return patchType, patch, nil
+}
+
+func (p *Patcher) patchSimple(obj runtime.Object, modified []byte, namespace, name string) ([]byte, runtime.Object, error) {
+	patchType, patch, err := p.CalculatePatch(obj, modified)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	if string(patch) == "{}" {
 		return patch, obj, nil
 	}
@@ -222,21 +267,29 @@ func (p *Patcher) deleteAndCreate(original runtime.Object, modified []byte, name
 }
 
 func (p *Patcher) delete(namespace, name string) error {
-	options := asDeleteOptions(p.Cascade, p.GracePeriod)
+	options := AsDeleteOptions(p.Cascade, p.GracePeriod)
 	_, err := p.Helper.DeleteWithOptions(namespace, name, &options)
 	return err
 }
 
-func asDeleteOptions(cascade bool, gracePeriod int) metav1.DeleteOptions {
-	options := metav1.DeleteOptions{}
-	if gracePeriod >= 0 {
-		options = *metav1.NewDeleteOptions(int64(gracePeriod))
-	}
+// AsDeleteOptions returns the delete options for the given cascade and grace period,
+// a negative grace period keeps the resource's default
+func AsDeleteOptions(cascade bool, gracePeriod int) metav1.DeleteOptions {
 	policy := metav1.DeletePropagationForeground
 	if !cascade {
 		policy = metav1.DeletePropagationOrphan
 	}
-	options.PropagationPolicy = &policy
+	return DeleteOptionsWithPolicy(&policy, gracePeriod)
+}
+
+// DeleteOptionsWithPolicy returns the delete options for the given propagation policy and grace period,
+// a nil policy or a negative grace period keeps the resource's default
+func DeleteOptionsWithPolicy(policy *metav1.DeletionPropagation, gracePeriod int) metav1.DeleteOptions {
+	options := metav1.DeleteOptions{}
+	if gracePeriod >= 0 {
+		options = *metav1.NewDeleteOptions(int64(gracePeriod))
+	}
+	options.PropagationPolicy = policy
 	return options
 }
 
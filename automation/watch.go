@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	WatchEventAdded    = "ADDED"
+	WatchEventModified = "MODIFIED"
+	WatchEventDeleted  = "DELETED"
+
+	// number of past events kept to resume streams from
+	watchHistorySize = 1000
+	// events buffered per subscriber before it is dropped as too slow
+	watchSubscriberBuffer = 256
+	// interval between SSE heartbeat comments
+	watchHeartbeatInterval = 15 * time.Second
+	// how long a new stream waits for the informer caches
+	watchSyncTimeout = 30 * time.Second
+)
+
+// kinds that can be watched, by the name used in the kinds query parameter
+var watchKinds = map[string]schema.GroupVersionKind{
+	"deployments": deploymentGVK,
+	"services":    serviceGVK,
+	"components":  componentGVK,
+}
+
+// WatchEvent is a change of a managed resource
+type WatchEvent struct {
+	Type            string      `json:"type"`
+	Kind            string      `json:"kind"`
+	Name            string      `json:"name"`
+	Namespace       string      `json:"namespace"`
+	ResourceVersion string      `json:"resourceVersion"`
+	Object          interface{} `json:"object"`
+
+	kindName string
+}
+
+type watchSubscriber struct {
+	namespace string
+	kinds     map[string]bool
+	events    chan WatchEvent
+	// closed when the subscriber fell too far behind
+	overflow chan struct{}
+}
+
+func (s *watchSubscriber) match(e WatchEvent) bool {
+	return s.kinds[e.kindName] && (s.namespace == "" || s.namespace == e.Namespace)
+}
+
+// WatchHub runs shared informers for managed resources and fans their events out to subscribers
+type WatchHub struct {
+	client  dynamic.Interface
+	mapper  meta.RESTMapper
+	factory dynamicinformer.DynamicSharedInformerFactory
+	stop    chan struct{}
+
+	// serializes starting informers, kinds start on first use
+	startMu sync.Mutex
+	synced  map[string]cache.InformerSynced
+
+	mu          sync.Mutex
+	stores      map[string]cache.Store
+	history     []WatchEvent
+	subscribers map[*watchSubscriber]struct{}
+}
+
+func NewWatchHub(client dynamic.Interface, mapper meta.RESTMapper) *WatchHub {
+	return &WatchHub{
+		client: client,
+		mapper: mapper,
+		factory: dynamicinformer.NewFilteredDynamicSharedInformerFactory(client, 0, metav1.NamespaceAll, func(opts *metav1.ListOptions) {
+			opts.LabelSelector = managedSelector()
+		}),
+		stop:        make(chan struct{}),
+		synced:      map[string]cache.InformerSynced{},
+		stores:      map[string]cache.Store{},
+		subscribers: map[*watchSubscriber]struct{}{},
+	}
+}
+
+// start the informers of the kinds that are not running yet and wait until they are synced,
+// they run for the lifetime of the server. Kinds the cluster does not serve, e.g. components
+// before Dapr is installed, are returned as unavailable and retried on the next call.
+func (h *WatchHub) start(ctx context.Context, kinds map[string]bool) (unavailable []string, err error) {
+	h.startMu.Lock()
+	waits := []cache.InformerSynced{}
+	for _, kindName := range sortedKinds(kinds) {
+		if synced, ok := h.synced[kindName]; ok {
+			waits = append(waits, synced)
+			continue
+		}
+		mapping, err := h.restMapping(watchKinds[kindName])
+		if meta.IsNoMatchError(err) {
+			unavailable = append(unavailable, kindName)
+			continue
+		}
+		if err != nil {
+			h.startMu.Unlock()
+			return nil, err
+		}
+		informer := h.factory.ForResource(mapping.Resource).Informer()
+		informer.AddEventHandler(h.handlerFor(kindName))
+		h.mu.Lock()
+		h.stores[kindName] = informer.GetStore()
+		h.mu.Unlock()
+		h.synced[kindName] = informer.HasSynced
+		waits = append(waits, informer.HasSynced)
+	}
+	h.factory.Start(h.stop)
+	h.startMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, watchSyncTimeout)
+	defer cancel()
+	if !cache.WaitForCacheSync(ctx.Done(), waits...) {
+		return unavailable, fmt.Errorf("timed out waiting for informer caches")
+	}
+	return unavailable, nil
+}
+
+// rediscover once when the kind is unknown, it may have been installed since the cache was filled
+func (h *WatchHub) restMapping(gvk schema.GroupVersionKind) (*meta.RESTMapping, error) {
+	mapping, err := h.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if meta.IsNoMatchError(err) {
+		if resettable, ok := h.mapper.(interface{ Reset() }); ok {
+			resettable.Reset()
+			mapping, err = h.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		}
+	}
+	return mapping, err
+}
+
+func sortedKinds(kinds map[string]bool) []string {
+	names := make([]string, 0, len(kinds))
+	for kindName := range kinds {
+		names = append(names, kindName)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (h *WatchHub) handlerFor(kindName string) cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { h.publish(WatchEventAdded, kindName, obj) },
+		UpdateFunc: func(_, obj interface{}) { h.publish(WatchEventModified, kindName, obj) },
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			h.publish(WatchEventDeleted, kindName, obj)
+		},
+	}
+}
+
+func (h *WatchHub) publish(eventType, kindName string, obj interface{}) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	event := newWatchEvent(eventType, kindName, u)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.history = append(h.history, event)
+	if len(h.history) > watchHistorySize {
+		h.history = h.history[len(h.history)-watchHistorySize:]
+	}
+	for sub := range h.subscribers {
+		if !sub.match(event) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			// too slow, drop it so it does not hold up everyone else
+			delete(h.subscribers, sub)
+			close(sub.overflow)
+		}
+	}
+}
+
+func newWatchEvent(eventType, kindName string, u *unstructured.Unstructured) WatchEvent {
+	event := WatchEvent{
+		Type:            eventType,
+		Kind:            u.GetKind(),
+		Name:            u.GetName(),
+		Namespace:       u.GetNamespace(),
+		ResourceVersion: u.GetResourceVersion(),
+		kindName:        kindName,
+	}
+	if kindName == "components" {
+		// never stream inline secrets
+		event.Object = componentInfo(u)
+	} else {
+		obj := u.DeepCopy()
+		unstructured.RemoveNestedField(obj.Object, "metadata", "managedFields")
+		event.Object = obj.Object
+	}
+	return event
+}
+
+// register a subscriber and return the events it missed: the history after resourceVersion,
+// or a snapshot of all current objects when resourceVersion is empty or too old
+func (h *WatchHub) subscribe(sub *watchSubscriber, resourceVersion string) (backlog []WatchEvent, resumed bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subscribers[sub] = struct{}{}
+
+	if resourceVersion != "" {
+		for i := len(h.history) - 1; i >= 0; i-- {
+			if h.history[i].ResourceVersion == resourceVersion {
+				for _, e := range h.history[i+1:] {
+					if sub.match(e) {
+						backlog = append(backlog, e)
+					}
+				}
+				return backlog, true
+			}
+		}
+	}
+
+	for kindName, store := range h.stores {
+		for _, obj := range store.List() {
+			if u, ok := obj.(*unstructured.Unstructured); ok {
+				if e := newWatchEvent(WatchEventAdded, kindName, u); sub.match(e) {
+					backlog = append(backlog, e)
+				}
+			}
+		}
+	}
+	return backlog, false
+}
+
+func (h *WatchHub) unsubscribe(sub *watchSubscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subscribers, sub)
+}
+
+// Stream changes of managed Deployments, Services and Components as Server-Sent Events
+func (s *Server) HandleWatch(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		HandleInternalServerError(w, r, fmt.Errorf("streaming is not supported"))
+		return
+	}
+
+	sub := &watchSubscriber{
+		namespace: r.URL.Query().Get("namespace"),
+		kinds:     map[string]bool{},
+		events:    make(chan WatchEvent, watchSubscriberBuffer),
+		overflow:  make(chan struct{}),
+	}
+	if kinds := r.URL.Query().Get("kinds"); kinds != "" {
+		for _, kind := range strings.Split(kinds, ",") {
+			kind = strings.ToLower(strings.TrimSpace(kind))
+			if _, ok := watchKinds[kind]; !ok {
+				HandleBadRequest(w, r, fmt.Errorf("unknown kind %q, expected deployments, services or components", kind))
+				return
+			}
+			sub.kinds[kind] = true
+		}
+	} else {
+		for kind := range watchKinds {
+			sub.kinds[kind] = true
+		}
+	}
+	explicitKinds := r.URL.Query().Get("kinds") != ""
+	resourceVersion := r.URL.Query().Get("resourceVersion")
+	if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" {
+		resourceVersion = lastEventID
+	}
+
+	hub := s.client(r).watchHub
+	unavailable, err := hub.start(ctx, sub.kinds)
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		HandleInternalServerError(w, r, err)
+		return
+	}
+	if len(unavailable) > 0 && explicitKinds {
+		HandleNotFound(w, r, fmt.Errorf("kinds not served by the cluster: %s", strings.Join(unavailable, ",")))
+		return
+	}
+	for _, kind := range unavailable {
+		delete(sub.kinds, kind)
+	}
+
+	backlog, resumed := hub.subscribe(sub, resourceVersion)
+	defer hub.unsubscribe(sub)
+	LoggerFrom(ctx).Info("HandleWatch", "namespace", sub.namespace, "resourceVersion", resourceVersion, "resumed", resumed, "backlog", len(backlog))
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if resourceVersion != "" && !resumed {
+		// the client must drop its state, a full snapshot follows
+		fmt.Fprintf(w, "event: RESYNC\ndata: {}\n\n")
+	}
+	if len(unavailable) > 0 {
+		// kinds left out of this stream, a new stream retries them
+		b, _ := json.Marshal(map[string][]string{"kinds": unavailable})
+		fmt.Fprintf(w, "event: UNAVAILABLE\ndata: %s\n\n", b)
+	}
+	for _, e := range backlog {
+		writeWatchEvent(w, e)
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(watchHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case e := <-sub.events:
+			writeWatchEvent(w, e)
+			flusher.Flush()
+		case <-heartbeat.C:
+			fmt.Fprintf(w, ": heartbeat\n\n")
+			flusher.Flush()
+		case <-sub.overflow:
+			fmt.Fprintf(w, "event: OVERFLOW\ndata: {}\n\n")
+			flusher.Flush()
+			LoggerFrom(ctx).Warn("watch subscriber dropped, too slow")
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func writeWatchEvent(w http.ResponseWriter, e WatchEvent) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", e.ResourceVersion, e.Type, b)
+}
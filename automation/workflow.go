@@ -1,18 +1,32 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"go.opentelemetry.io/otel/attribute"
+	"k8s.io/apimachinery/pkg/api/errors"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 )
 
-func (k *KubeClient) ConnectDCS(req *DCSConnectRequest) (string, error) {
-	// find DCS
-	redisHost, noPasswordAccess, password, err := FindDCS(req)
+func (k *KubeClient) ConnectDCS(ctx context.Context, req *DCSConnectRequest) (string, error) {
+	meta, err := k.connectDCS(ctx, req, managedLabels(""))
 	if err != nil {
 		return "", err
 	}
+	b, _ := json.Marshal(meta)
+
+	return string(b), nil
+}
+
+// apply the DCS state store Component with the given labels
+func (k *KubeClient) connectDCS(ctx context.Context, req *DCSConnectRequest, labels map[string]interface{}) (Metadata, error) {
+	// find DCS
+	redisHost, noPasswordAccess, password, err := FindDCS(ctx, req)
+	if err != nil {
+		return Metadata{}, err
+	}
 	redisPassword := ""
 	if noPasswordAccess == "false" {
 		redisPassword = password
@@ -32,7 +46,8 @@ func (k *KubeClient) ConnectDCS(req *DCSConnectRequest) (string, error) {
 		"apiVersion": "dapr.io/v1alpha1",
 		"kind":       "Component",
 		"metadata": map[string]interface{}{
-			"name": req.Name,
+			"name":   req.Name,
+			"labels": labels,
 		},
 		"spec": map[string]interface{}{
 			"type":     "state.redis",
@@ -43,38 +58,101 @@ func (k *KubeClient) ConnectDCS(req *DCSConnectRequest) (string, error) {
 
 	yaml, err := ToUnstructured(redis)
 	if err != nil {
-		return "", err
+		return Metadata{}, err
 	}
 
-	meta, err := k.ApplyWithNamespaceOverride(yaml, req.Namespace)
+	unlock, err := k.appLocks.lockComponent(ctx, req.Namespace, req.Name)
 	if err != nil {
-		return "", err
+		return Metadata{}, err
 	}
-	b, _ := json.Marshal(meta)
+	defer unlock()
+	meta, err := k.Apply(ctx, yaml, ApplyOptions{NamespaceOverride: req.Namespace, ResourceVersion: req.ResourceVersion})
+	if err != nil {
+		return Metadata{}, err
+	}
+	LoggerFrom(ctx).Info("DCS connected", "component", meta.Name, "namespace", meta.Namespace)
 
-	return string(b), nil
+	return meta, nil
 }
 
-func (k *KubeClient) DisconnectDCS(req *DCSDisconnectRequest) (string, error) {
-	err := k.DeleteResourceByKindAndNameAndNamespace("Component", req.Name, req.Namespace, metav1.DeleteOptions{})
+func (k *KubeClient) DisconnectDCS(ctx context.Context, req *DCSDisconnectRequest) (string, error) {
+	do, err := req.deleteOptions(true)
 	if err != nil {
 		return "", err
 	}
+	unlock, err := k.appLocks.lockComponent(ctx, req.Namespace, req.Name)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+	err = k.DeleteResourceByKindAndNameAndNamespace(ctx, componentGVK, req.Name, req.Namespace, do)
+	if err != nil {
+		return "", err
+	}
+	if req.Wait {
+		if err := k.WaitForDeletion(ctx, componentGVK, req.Name, req.Namespace, req.timeout()); err != nil {
+			return "", err
+		}
+	}
 	return "Dapr StateStore Disconnected.", nil
 }
 
-func (k *KubeClient) CreateAppDeploy(req *AppCreateRequest) (string, error) {
+func (k *KubeClient) CreateAppDeploy(ctx context.Context, req *AppCreateRequest) (_ string, err error) {
+	ctx, span := StartSpan(ctx, "CreateAppDeploy")
+	defer func() { EndSpan(span, err) }()
 
-	// connect to DCS
-	redisResult, err := k.ConnectDCS(&req.DCSConnect)
+	// parse Deployment template
+	deploymentYAML, err := ToUnstructured(req.Deployment)
 	if err != nil {
 		return "", err
 	}
-	// parse Deployment template
-	deploymentYAML, err := ToUnstructured(req.Deployment)
+	appName := deploymentYAML.GetName()
+	span.SetAttributes(attribute.String("app.name", appName))
+
+	// the Deployment always goes to the default namespace
+	unlock, err := k.appLocks.lock(ctx, "default", appName)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	// the expected version is the Deployment's, it is checked before anything is changed
+	// and the Service built from the Deployment's metadata must not inherit it
+	expectedVersion := req.ResourceVersion
+	if expectedVersion == "" {
+		expectedVersion = deploymentYAML.GetResourceVersion()
+	}
+	deploymentYAML.SetResourceVersion("")
+	if expectedVersion != "" {
+		if err := k.checkResourceVersion(ctx, deploymentYAML, "default", expectedVersion); err != nil {
+			return "", err
+		}
+	}
+
+	// connect to DCS
+	stepCtx, stepSpan := StartSpan(ctx, "CreateAppDeploy.ConnectDCS")
+	redisMeta, err := k.connectDCS(stepCtx, &req.DCSConnect, managedLabels(appName))
+	EndSpan(stepSpan, err)
 	if err != nil {
 		return "", err
 	}
+	redisJson, _ := json.Marshal(redisMeta)
+
+	// label the Deployment and Service so they can be found again, and link the state store
+	labels := deploymentYAML.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	for key, value := range managedLabels(appName) {
+		labels[key] = value.(string)
+	}
+	deploymentYAML.SetLabels(labels)
+	annotations := deploymentYAML.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[StateStoreAnnotation] = redisMeta.Namespace + "/" + redisMeta.Name
+	deploymentYAML.SetAnnotations(annotations)
 	metadata := deploymentYAML.Object["metadata"].(map[string]interface{})
 	app := metadata["labels"].(map[string]interface{})["app"]
 	containerPort := deploymentYAML.Object["spec"].(map[string]interface{})["template"].(map[string]interface{})["spec"].(map[string]interface{})["containers"].([]interface{})[0].(map[string]interface{})["ports"].([]interface{})[0].(map[string]interface{})["containerPort"]
@@ -105,39 +183,102 @@ func (k *KubeClient) CreateAppDeploy(req *AppCreateRequest) (string, error) {
 	}
 
 	// apply Service
-	serviceResult, err := k.ApplyWithNamespaceOverride(serviceYAML, "default")
+	stepCtx, stepSpan = StartSpan(ctx, "CreateAppDeploy.ApplyService")
+	serviceResult, err := k.ApplyWithNamespaceOverride(stepCtx, serviceYAML, "default")
+	EndSpan(stepSpan, err)
 	if err != nil {
 		return "", err
 	}
 	serviceJson, _ := json.Marshal(serviceResult)
 	// apply Deployment
-	deploymentResult, err := k.ApplyWithNamespaceOverride(deploymentYAML, "default")
+	stepCtx, stepSpan = StartSpan(ctx, "CreateAppDeploy.ApplyDeployment")
+	deploymentResult, err := k.Apply(stepCtx, deploymentYAML, ApplyOptions{NamespaceOverride: "default", ResourceVersion: expectedVersion})
+	EndSpan(stepSpan, err)
 	if err != nil {
 		return "", err
 	}
 	deploymentJson, _ := json.Marshal(deploymentResult)
 
-	return "App Created \n" + redisResult + "\n" + string(serviceJson) + "\n" + string(deploymentJson), nil
+	return "App Created \n" + string(redisJson) + "\n" + string(serviceJson) + "\n" + string(deploymentJson), nil
 }
 
-func (k *KubeClient) DeleteAppDeploy(req *AppDeleteRequest) (string, error) {
-	// delete Service
-	err := k.DeleteResourceByKindAndNameAndNamespace("Service", req.Name, req.Namespace, metav1.DeleteOptions{})
+func (k *KubeClient) DeleteAppDeploy(ctx context.Context, req *AppDeleteRequest) (_ string, err error) {
+	ctx, span := StartSpan(ctx, "DeleteAppDeploy",
+		attribute.String("app.name", req.Name),
+		attribute.String("app.namespace", req.Namespace))
+	defer func() { EndSpan(span, err) }()
+
+	unlock, err := k.appLocks.lock(ctx, req.Namespace, req.Name)
 	if err != nil {
 		return "", err
 	}
+	defer unlock()
 
-	// delete Deployment
-	err = k.DeleteResourceByKindAndNameAndNamespace("Deployment", req.Name, req.Namespace, metav1.DeleteOptions{})
+	serviceOptions, err := req.deleteOptions(false)
 	if err != nil {
 		return "", err
 	}
-
-	// diconnect DCS
-	result, err := k.DisconnectDCS(&req.DCSDisconnect)
+	deploymentOptions, err := req.deleteOptions(true)
 	if err != nil {
 		return "", err
 	}
+
+	// keep going when a step fails so a half deleted app can be cleaned up by retrying
+	errs := []error{}
+	step := func(name string, f func(ctx context.Context) error) {
+		stepCtx, stepSpan := StartSpan(ctx, "DeleteAppDeploy."+name)
+		err := f(stepCtx)
+		EndSpan(stepSpan, err)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+
+	// delete Deployment first, nothing else is touched when its preconditions do not hold
+	step("DeleteDeployment", func(ctx context.Context) error {
+		return k.DeleteResourceByKindAndNameAndNamespace(ctx, deploymentGVK, req.Name, req.Namespace, deploymentOptions)
+	})
+	if len(errs) > 0 && errors.IsConflict(errs[0]) {
+		return "", errs[0]
+	}
+
+	// delete Service
+	step("DeleteService", func(ctx context.Context) error {
+		return k.DeleteResourceByKindAndNameAndNamespace(ctx, serviceGVK, req.Name, req.Namespace, serviceOptions)
+	})
+
+	// diconnect DCS, its wait is shared with the other resources below
+	disconnect := req.DCSDisconnect
+	waitDCS, waitTimeout := disconnect.Wait, disconnect.timeout()
+	if req.Wait {
+		waitDCS, waitTimeout = true, req.timeout()
+	}
+	disconnect.Wait = false
+	var result string
+	step("DisconnectDCS", func(ctx context.Context) (err error) {
+		result, err = k.DisconnectDCS(ctx, &disconnect)
+		return err
+	})
+
+	// wait for everything against one deadline
+	if waitDCS {
+		step("WaitForDeletion", func(ctx context.Context) error {
+			targets := []deletionTarget{{componentGVK, disconnect.Name, disconnect.Namespace}}
+			if req.Wait {
+				targets = append(targets, deletionTarget{serviceGVK, req.Name, req.Namespace}, deletionTarget{deploymentGVK, req.Name, req.Namespace})
+			}
+			return k.WaitForDeletions(ctx, targets, waitTimeout)
+		})
+	}
+
+	switch len(errs) {
+	case 0:
+	case 1:
+		// keep a single error unaggregated so its API status reaches the client
+		return "", errs[0]
+	default:
+		return "", utilerrors.NewAggregate(errs)
+	}
 	str := result + "App has been deleted."
 	return fmt.Sprintln(str), nil
 }